@@ -0,0 +1,26 @@
+package composer
+
+import "fmt"
+
+// AggregateSubject is the subject aggregations are computed over
+const AggregateSubject = "data"
+
+// IsAggregatable tells whether clients may group by or aggregate the result
+// column name of the aggregated subject
+func (d *Doc) IsAggregatable(name string) bool {
+	for _, f := range d.Aggregatable {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Doc) validateAggregatable() error {
+	for _, f := range d.Aggregatable {
+		if !paramNamePattern.MatchString(f) {
+			return fmt.Errorf("invalid aggregatable column %q", f)
+		}
+	}
+	return nil
+}
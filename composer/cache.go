@@ -0,0 +1,48 @@
+package composer
+
+import (
+	"github.com/user/sqlcomposer-svc/models"
+	"sync"
+)
+
+// Cache keeps compiled documents by path. An entry is recompiled when the
+// updated_at of the row no longer matches or after it has been invalidated.
+type Cache struct {
+	mu   sync.RWMutex
+	docs map[string]*Compiled
+}
+
+func NewCache() *Cache {
+	return &Cache{
+		docs: make(map[string]*Compiled),
+	}
+}
+
+func (c *Cache) Get(d *models.Doc) (*Compiled, error) {
+	path := d.Path.String
+
+	c.mu.RLock()
+	cd, ok := c.docs[path]
+	c.mu.RUnlock()
+
+	if ok && cd.UpdatedAt.Equal(d.UpdatedAt.Time) && cd.DBName == d.DBName.String {
+		return cd, nil
+	}
+
+	cd, err := Compile(d)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.docs[path] = cd
+	c.mu.Unlock()
+
+	return cd, nil
+}
+
+func (c *Cache) Invalidate(path string) {
+	c.mu.Lock()
+	delete(c.docs, path)
+	c.mu.Unlock()
+}
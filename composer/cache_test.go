@@ -0,0 +1,95 @@
+package composer
+
+import (
+	"github.com/user/sqlcomposer-svc/models"
+	"github.com/volatiletech/null/v8"
+	"testing"
+	"time"
+)
+
+const testDoc = `
+info:
+  name: orders
+  version: 1.0.0
+composition:
+  default_conditions:
+    - attr: status
+      op: "="
+      val: A
+  subject:
+    data: "SELECT id FROM orders %where"
+`
+
+func testDocRow(content string, updatedAt time.Time) *models.Doc {
+	return &models.Doc{
+		Path:      null.StringFrom("/orders"),
+		DBName:    null.StringFrom("lite"),
+		Content:   null.StringFrom(content),
+		UpdatedAt: null.TimeFrom(updatedAt),
+	}
+}
+
+func TestCacheGet(t *testing.T) {
+	c := NewCache()
+	at := time.Date(2020, 8, 1, 0, 0, 0, 0, time.UTC)
+	row := testDocRow(testDoc, at)
+
+	first, err := c.Get(row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := c.Get(row); again != first {
+		t.Error("unchanged doc compiled again")
+	}
+
+	row.UpdatedAt = null.TimeFrom(at.Add(time.Second))
+	updated, err := c.Get(row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated == first {
+		t.Error("updated doc served from the cache")
+	}
+
+	row.DBName = null.StringFrom("other")
+	moved, _ := c.Get(row)
+	if moved == updated || moved.DBName != "other" {
+		t.Error("doc of another datasource served from the cache")
+	}
+
+	c.Invalidate("/orders")
+	if again, _ := c.Get(row); again == moved {
+		t.Error("invalidated doc served from the cache")
+	}
+
+	if _, err := c.Get(testDocRow("composition: [", at)); err == nil {
+		t.Error("invalid doc compiled")
+	}
+}
+
+func TestNewBuilderClonesConditions(t *testing.T) {
+	cd, err := Compile(testDocRow(testDoc, time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := cd.NewBuilder(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := cd.NewBuilder(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a.Conditions.Arg["extra"] = 1
+	if _, ok := b.Conditions.Arg["extra"]; ok {
+		t.Error("builders share their condition args")
+	}
+	if _, ok := cd.defaults.Arg["extra"]; ok {
+		t.Error("a builder wrote into the default conditions")
+	}
+	if len(b.Conditions.Arg) != len(cd.defaults.Arg) || b.Conditions.Clause != cd.defaults.Clause {
+		t.Errorf("builder conditions %+v differ from the defaults %+v", b.Conditions, cd.defaults)
+	}
+}
@@ -0,0 +1,249 @@
+package composer
+
+import (
+	"github.com/friendsofgo/errors"
+	"github.com/jmoiron/sqlx"
+	"github.com/user/sqlcomposer-svc/models"
+	"github.com/wangxb07/sqlcomposer"
+	"gopkg.in/yaml.v2"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// emptyDoc is handed to sqlcomposer.NewSqlBuilder so that building a builder
+// from an already compiled document does not parse its yaml again.
+var emptyDoc = []byte("{}")
+
+// DefaultConcurrency is used when a document does not set options.concurrency.
+const DefaultConcurrency = 4
+
+// Doc is the yaml document stored in doc.content. Besides the sqlcomposer
+// definition it carries the service options of the document.
+type Doc struct {
+	sqlcomposer.SqlApiDoc `yaml:",inline"`
+	Options               Options `yaml:"options,omitempty"`
+	// Filters whitelists the attributes clients may filter on
+	Filters []FilterSpec `yaml:"filters,omitempty"`
+	// Sortable whitelists the fields clients may sort on
+	Sortable []string `yaml:"sortable,omitempty"`
+	// Params declares the named inputs of the document
+	Params []ParamSpec `yaml:"params,omitempty"`
+	// Aggregatable lists the result columns of the data subject clients may
+	// group by and aggregate
+	Aggregatable []string `yaml:"aggregatable,omitempty"`
+	// Facets enables value counts of result columns of the data subject
+	Facets []FacetSpec `yaml:"facets,omitempty"`
+	// Suggest lists the result columns of the data subject offering prefix
+	// suggestions
+	Suggest []SuggestSpec `yaml:"suggest,omitempty"`
+}
+
+type Options struct {
+	// Concurrency bounds how many subjects of one request are executed at the same time
+	Concurrency int `yaml:"concurrency,omitempty"`
+	// Cursor enables keyset pagination for the document
+	Cursor *CursorOptions `yaml:"cursor,omitempty"`
+	// DecimalAsString returns decimal columns as strings by default
+	DecimalAsString bool `yaml:"decimal_as_string,omitempty"`
+	// Timeout bounds the execution of one request, e.g. 10s
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// DefaultSort applies when a request has no sorts
+	DefaultSort []SortSpec `yaml:"default_sort,omitempty"`
+	// Mutation allows subjects that are not reads, e.g. UPDATE or DELETE
+	Mutation bool `yaml:"mutation,omitempty"`
+	// Snapshot runs the subjects of a request one after the other in a single
+	// consistent snapshot, so the total and the rows agree
+	Snapshot bool `yaml:"snapshot,omitempty"`
+}
+
+type CursorOptions struct {
+	// Key is a unique field, appended to the sorts to break ties
+	Key string `yaml:"key"`
+	// Nullable lists the sortable fields that may be null, the cursor orders
+	// their nulls explicitly
+	Nullable []string `yaml:"nullable,omitempty"`
+}
+
+// SubjectKeys returns the subject names in a stable order.
+func (d *Doc) SubjectKeys() []string {
+	keys := make([]string, 0, len(d.Composition.Subject))
+	for k := range d.Composition.Subject {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// FieldExpr returns the expression of the composition field called name, or
+// name itself when no field has that name.
+func (d *Doc) FieldExpr(name string) string {
+	if f, ok := d.Field(name); ok {
+		return f.Expr
+	}
+	return name
+}
+
+// Field looks name up in the composition fields, groups are searched in name order.
+func (d *Doc) Field(name string) (sqlcomposer.SqlCompositionField, bool) {
+	groups := make([]string, 0, len(d.Composition.Fields))
+	for g := range d.Composition.Fields {
+		groups = append(groups, g)
+	}
+	sort.Strings(groups)
+
+	for _, g := range groups {
+		for _, f := range d.Composition.Fields[g] {
+			if f.Name == name {
+				return f, true
+			}
+		}
+	}
+
+	return sqlcomposer.SqlCompositionField{}, false
+}
+
+// Project returns a copy of the sqlcomposer document whose field groups only
+// select the fields in names. A group without any of them keeps its first
+// field so that the statements using it stay valid.
+func (d *Doc) Project(names map[string]bool) *sqlcomposer.SqlApiDoc {
+	pd := d.SqlApiDoc
+	pd.Composition.Fields = make(sqlcomposer.SqlCompositionFields, len(d.Composition.Fields))
+
+	for g, fields := range d.Composition.Fields {
+		var group sqlcomposer.SqlCompositionFieldGroup
+		for _, f := range fields {
+			if names[f.Name] {
+				group = append(group, f)
+			}
+		}
+		if len(group) == 0 && len(fields) > 0 {
+			group = append(group, fields[0])
+		}
+		pd.Composition.Fields[g] = group
+	}
+
+	return &pd
+}
+
+// limitToken matches the %limit token of a statement and the character after it
+var limitToken = regexp.MustCompile(`%limit($|[^\w.{])`)
+
+// WithoutLimit returns a copy of sd whose subject key has no %limit token, for
+// the statements that wrap the subject and read all of its rows.
+func WithoutLimit(sd *sqlcomposer.SqlApiDoc, key string) *sqlcomposer.SqlApiDoc {
+	ud := *sd
+	ud.Composition.Subject = make(map[string]string, len(sd.Composition.Subject))
+	for k, v := range sd.Composition.Subject {
+		ud.Composition.Subject[k] = v
+	}
+	ud.Composition.Subject[key] = limitToken.ReplaceAllString(sd.Composition.Subject[key], "$1")
+
+	return &ud
+}
+
+func (d *Doc) Concurrency() int {
+	if d.Options.Concurrency < 1 {
+		return DefaultConcurrency
+	}
+	return d.Options.Concurrency
+}
+
+// Compiled is a parsed document ready to produce builders.
+type Compiled struct {
+	Path      string
+	DBName    string
+	UpdatedAt time.Time
+	Doc       *Doc
+
+	defaults sqlcomposer.ConditionStmt
+}
+
+func Compile(d *models.Doc) (*Compiled, error) {
+	var doc Doc
+
+	if err := yaml.Unmarshal([]byte(d.Content.String), &doc); err != nil {
+		return nil, errors.Wrap(err, "doc parse failure")
+	}
+
+	if doc.Options.Snapshot && doc.Options.Mutation {
+		return nil, errors.New("doc options invalid: a snapshot is read only, it can not be a mutation")
+	}
+
+	if err := doc.validateFilters(); err != nil {
+		return nil, errors.Wrap(err, "doc filters invalid")
+	}
+
+	if err := doc.validateSorts(); err != nil {
+		return nil, errors.Wrap(err, "doc sorts invalid")
+	}
+
+	if err := doc.validateParams(); err != nil {
+		return nil, errors.Wrap(err, "doc params invalid")
+	}
+
+	if err := doc.validateAggregatable(); err != nil {
+		return nil, errors.Wrap(err, "doc aggregatable invalid")
+	}
+
+	if err := doc.validateFacets(); err != nil {
+		return nil, errors.Wrap(err, "doc facets invalid")
+	}
+
+	if err := doc.validateSuggest(); err != nil {
+		return nil, errors.Wrap(err, "doc suggest invalid")
+	}
+
+	cd := &Compiled{
+		Path:      d.Path.String,
+		DBName:    d.DBName.String,
+		UpdatedAt: d.UpdatedAt.Time,
+		Doc:       &doc,
+	}
+
+	if doc.Composition.DefaultConditions != nil {
+		defaults, err := sqlcomposer.WhereAnd(&doc.Composition.DefaultConditions)
+		if err != nil {
+			return nil, errors.Wrap(err, "default conditions process failure")
+		}
+		cd.defaults = defaults
+	}
+
+	return cd, nil
+}
+
+// NewBuilder returns a fresh builder for one request. The document is shared
+// between builders and must be treated as read only.
+func (cd *Compiled) NewBuilder(db *sqlx.DB) (*sqlcomposer.SqlBuilder, error) {
+	sb, err := sqlcomposer.NewSqlBuilder(db, emptyDoc)
+	if err != nil {
+		return nil, err
+	}
+
+	conditions := cloneConditions(cd.defaults)
+
+	sb.Doc = &cd.Doc.SqlApiDoc
+	sb.SetConditions(&conditions)
+
+	return sb, nil
+}
+
+// cloneConditions copies the maps of c, sqlcomposer.Combine writes into the
+// ClauseSlice of the statements it combines.
+func cloneConditions(c sqlcomposer.ConditionStmt) sqlcomposer.ConditionStmt {
+	nc := sqlcomposer.ConditionStmt{
+		Clause:      c.Clause,
+		Arg:         make(map[string]interface{}, len(c.Arg)),
+		ClauseSlice: make(map[string]string, len(c.ClauseSlice)),
+	}
+
+	for k, v := range c.Arg {
+		nc.Arg[k] = v
+	}
+
+	for k, v := range c.ClauseSlice {
+		nc.ClauseSlice[k] = v
+	}
+
+	return nc
+}
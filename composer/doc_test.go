@@ -0,0 +1,123 @@
+package composer
+
+import (
+	"testing"
+	"time"
+)
+
+const testFieldsDoc = `
+composition:
+  fields:
+    base:
+      - name: id
+        expr: orders.id
+      - name: status
+        expr: orders.status
+    extra:
+      - name: note
+        expr: orders.note
+      - name: amount
+        expr: orders.amount
+  subject:
+    data: "SELECT %fields.base, %fields.extra FROM orders"
+`
+
+func TestDocProject(t *testing.T) {
+	cd, err := Compile(testDocRow(testFieldsDoc, time.Time{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		names map[string]bool
+		want  map[string][]string
+	}{
+		{
+			map[string]bool{"status": true, "amount": true},
+			map[string][]string{"base": {"status"}, "extra": {"amount"}},
+		},
+		{
+			// a group without requested fields keeps its first one
+			map[string]bool{"id": true},
+			map[string][]string{"base": {"id"}, "extra": {"note"}},
+		},
+		{
+			// names that are not fields select nothing more
+			map[string]bool{"note": true, "missing": true, "orders.id": true},
+			map[string][]string{"base": {"id"}, "extra": {"note"}},
+		},
+		{
+			map[string]bool{},
+			map[string][]string{"base": {"id"}, "extra": {"note"}},
+		},
+	}
+	for _, tt := range tests {
+		pd := cd.Doc.Project(tt.names)
+
+		for g, want := range tt.want {
+			got := pd.Composition.Fields[g]
+			if len(got) != len(want) {
+				t.Errorf("%v: group %s is %+v, want %v", tt.names, g, got, want)
+				continue
+			}
+			for i := range got {
+				if got[i].Name != want[i] {
+					t.Errorf("%v: group %s is %+v, want %v", tt.names, g, got, want)
+					break
+				}
+			}
+		}
+	}
+
+	// the compiled doc is shared between requests
+	if len(cd.Doc.Composition.Fields["base"]) != 2 || len(cd.Doc.Composition.Fields["extra"]) != 2 {
+		t.Errorf("projecting changed the doc: %+v", cd.Doc.Composition.Fields)
+	}
+}
+
+func TestWithoutLimit(t *testing.T) {
+	tests := []struct {
+		subject string
+		want    string
+	}{
+		{"SELECT id FROM orders %where %limit", "SELECT id FROM orders %where "},
+		{"SELECT id FROM orders %limit\nUNION SELECT 1", "SELECT id FROM orders \nUNION SELECT 1"},
+		{"SELECT id FROM (SELECT id FROM orders %limit) AS o %limit", "SELECT id FROM (SELECT id FROM orders ) AS o "},
+		{"SELECT id FROM orders %where %limits", "SELECT id FROM orders %where %limits"},
+		{"SELECT id FROM orders %limit.page", "SELECT id FROM orders %limit.page"},
+	}
+	for _, tt := range tests {
+		sd := &Doc{}
+		sd.Composition.Subject = map[string]string{"data": tt.subject, "total": tt.subject}
+
+		ud := WithoutLimit(&sd.SqlApiDoc, "data")
+		if got := ud.Composition.Subject["data"]; got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.subject, got, tt.want)
+		}
+		if ud.Composition.Subject["total"] != tt.subject || sd.Composition.Subject["data"] != tt.subject {
+			t.Errorf("%q: changed another subject or the doc", tt.subject)
+		}
+	}
+}
+
+func TestCompileOptions(t *testing.T) {
+	const subject = `
+composition:
+  subject:
+    data: "SELECT id FROM orders"
+`
+	tests := []struct {
+		options string
+		ok      bool
+	}{
+		{"", true},
+		{"options:\n  snapshot: true\n", true},
+		{"options:\n  mutation: true\n", true},
+		{"options:\n  snapshot: true\n  mutation: true\n", false},
+	}
+	for _, tt := range tests {
+		if _, err := Compile(testDocRow(subject+tt.options, time.Time{})); (err == nil) != tt.ok {
+			t.Errorf("Compile(%q) = %v, want ok %v", tt.options, err, tt.ok)
+		}
+	}
+}
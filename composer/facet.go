@@ -0,0 +1,59 @@
+package composer
+
+import "fmt"
+
+// DefaultFacetLimit is the number of values of a facet when neither the
+// request nor the doc sets one
+const DefaultFacetLimit = 10
+
+// FacetSpec enables value counts for the result column Field of the data
+// subject. Attr is the filter attribute of the column, its filters are left
+// out when counting so that the other values stay selectable.
+type FacetSpec struct {
+	Field string `yaml:"field"`
+	Attr  string `yaml:"attr,omitempty"`
+	Limit int    `yaml:"limit,omitempty"`
+}
+
+// FacetSpec looks the facet of field up
+func (d *Doc) FacetSpec(field string) (*FacetSpec, bool) {
+	for i := range d.Facets {
+		if d.Facets[i].Field == field {
+			return &d.Facets[i], true
+		}
+	}
+	return nil, false
+}
+
+func (d *Doc) validateFacets() error {
+	if len(d.Facets) == 0 {
+		return nil
+	}
+	// facets count the rows of the data subject
+	if _, ok := d.Composition.Subject[AggregateSubject]; !ok {
+		return fmt.Errorf("doc has no %s subject to count facets over", AggregateSubject)
+	}
+
+	seen := make(map[string]bool, len(d.Facets))
+
+	for i := range d.Facets {
+		fs := &d.Facets[i]
+
+		if !paramNamePattern.MatchString(fs.Field) {
+			return fmt.Errorf("invalid facet field %q", fs.Field)
+		}
+		if seen[fs.Field] {
+			return fmt.Errorf("facet %s is declared twice", fs.Field)
+		}
+		seen[fs.Field] = true
+
+		if fs.Attr == "" {
+			fs.Attr = fs.Field
+		}
+		if fs.Limit <= 0 {
+			fs.Limit = DefaultFacetLimit
+		}
+	}
+
+	return nil
+}
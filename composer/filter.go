@@ -0,0 +1,101 @@
+package composer
+
+import (
+	"fmt"
+	"github.com/wangxb07/sqlcomposer"
+)
+
+// Operators lists every operator understood by sqlcomposer.
+var Operators = []sqlcomposer.Operator{
+	sqlcomposer.Equal,
+	sqlcomposer.NotEqual,
+	sqlcomposer.Greater,
+	sqlcomposer.Less,
+	sqlcomposer.GreaterOrEqual,
+	sqlcomposer.LessOrEqual,
+	sqlcomposer.StartsWith,
+	sqlcomposer.Contains,
+	sqlcomposer.EndsWith,
+	sqlcomposer.In,
+	sqlcomposer.NotIn,
+	sqlcomposer.Between,
+	sqlcomposer.NotBetween,
+	sqlcomposer.IsNull,
+	sqlcomposer.IsNotNull,
+}
+
+// FilterSpec declares an attribute clients may filter on. Ops defaults to
+// every operator, Type to any scalar value.
+type FilterSpec struct {
+	Attr string                 `yaml:"attr"`
+	Ops  []sqlcomposer.Operator `yaml:"ops,omitempty"`
+	Type string                 `yaml:"type,omitempty"`
+}
+
+// Allows tells whether op may be used on the attribute
+func (fs *FilterSpec) Allows(op sqlcomposer.Operator) bool {
+	if len(fs.Ops) == 0 {
+		return IsOperator(op)
+	}
+	for _, o := range fs.Ops {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+func IsOperator(op sqlcomposer.Operator) bool {
+	for _, o := range Operators {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterSpec looks the declaration of attr up. Clients may only filter on
+// the declared attributes. A document without a filters section accepts any
+// plain or table qualified column name with every operator, as before filters
+// were declared, an empty section accepts no filters.
+func (d *Doc) FilterSpec(attr string) (*FilterSpec, bool) {
+	if d.Filters == nil {
+		if !identifierPattern.MatchString(attr) {
+			return nil, false
+		}
+		return &FilterSpec{Attr: attr}, true
+	}
+
+	for i := range d.Filters {
+		if d.Filters[i].Attr == attr {
+			return &d.Filters[i], true
+		}
+	}
+	return nil, false
+}
+
+func (d *Doc) validateFilters() error {
+	seen := make(map[string]bool, len(d.Filters))
+
+	for _, fs := range d.Filters {
+		if fs.Attr == "" {
+			return fmt.Errorf("filter without attr")
+		}
+		if seen[fs.Attr] {
+			return fmt.Errorf("filter %s is declared twice", fs.Attr)
+		}
+		seen[fs.Attr] = true
+
+		for _, op := range fs.Ops {
+			if !IsOperator(op) {
+				return fmt.Errorf("filter %s: unknown operator %s", fs.Attr, op)
+			}
+		}
+
+		if fs.Type != "" && !isValueType(fs.Type) {
+			return fmt.Errorf("filter %s: unknown type %s", fs.Attr, fs.Type)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package composer
+
+import (
+	"fmt"
+	"regexp"
+	"unicode/utf8"
+)
+
+// ParamPrefix qualifies the placeholders of params, a doc refers to the
+// param named x as :params.x
+const ParamPrefix = "params."
+
+var paramNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ParamSpec declares a named input of the document. Min and Max bound the
+// value of numbers and the length of strings.
+type ParamSpec struct {
+	Name     string        `yaml:"name"`
+	Type     string        `yaml:"type,omitempty"`
+	Required bool          `yaml:"required,omitempty"`
+	Default  interface{}   `yaml:"default,omitempty"`
+	Enum     []interface{} `yaml:"enum,omitempty"`
+	Min      *float64      `yaml:"min,omitempty"`
+	Max      *float64      `yaml:"max,omitempty"`
+	Regexp   string        `yaml:"regexp,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// Check validates a value supplied for the param
+func (ps *ParamSpec) Check(v interface{}) error {
+	if err := CheckValue(ps.Type, v); err != nil {
+		return err
+	}
+
+	if len(ps.Enum) > 0 {
+		found := false
+		for _, e := range ps.Enum {
+			if sameValue(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("value %v is not one of %v", v, ps.Enum)
+		}
+	}
+
+	if n, ok := Number(v); ok {
+		if ps.Min != nil && n < *ps.Min {
+			return fmt.Errorf("value %v is less than %v", v, *ps.Min)
+		}
+		if ps.Max != nil && n > *ps.Max {
+			return fmt.Errorf("value %v is greater than %v", v, *ps.Max)
+		}
+	}
+
+	if s, ok := v.(string); ok {
+		l := float64(utf8.RuneCountInString(s))
+		if ps.Min != nil && l < *ps.Min {
+			return fmt.Errorf("value is shorter than %v", *ps.Min)
+		}
+		if ps.Max != nil && l > *ps.Max {
+			return fmt.Errorf("value is longer than %v", *ps.Max)
+		}
+		if ps.re != nil && !ps.re.MatchString(s) {
+			return fmt.Errorf("value does not match %s", ps.Regexp)
+		}
+	}
+
+	return nil
+}
+
+// sameValue compares an enum entry of the yaml with a value of the json,
+// numbers are compared by value.
+func sameValue(a, b interface{}) bool {
+	if na, ok := Number(a); ok {
+		nb, ok := Number(b)
+		return ok && na == nb
+	}
+	return a == b
+}
+
+func (d *Doc) validateParams() error {
+	seen := make(map[string]bool, len(d.Params))
+
+	for i := range d.Params {
+		ps := &d.Params[i]
+
+		if !paramNamePattern.MatchString(ps.Name) {
+			return fmt.Errorf("invalid param name %q", ps.Name)
+		}
+		if seen[ps.Name] {
+			return fmt.Errorf("param %s is declared twice", ps.Name)
+		}
+		seen[ps.Name] = true
+
+		if ps.Type != "" && !isValueType(ps.Type) {
+			return fmt.Errorf("param %s: unknown type %s", ps.Name, ps.Type)
+		}
+
+		if err := ps.prepare(); err != nil {
+			return fmt.Errorf("param %s: %v", ps.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// prepare compiles the regexp of ps and checks its default.
+func (ps *ParamSpec) prepare() error {
+	if ps.Regexp != "" {
+		re, err := regexp.Compile(ps.Regexp)
+		if err != nil {
+			return err
+		}
+		ps.re = re
+	}
+
+	if ps.Default != nil {
+		if err := ps.Check(ps.Default); err != nil {
+			return fmt.Errorf("default: %v", err)
+		}
+	}
+
+	return nil
+}
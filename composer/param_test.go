@@ -0,0 +1,82 @@
+package composer
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParamCheck(t *testing.T) {
+	one, ten := 1.0, 10.0
+
+	tests := []struct {
+		spec ParamSpec
+		v    interface{}
+		ok   bool
+	}{
+		{ParamSpec{Type: TypeString}, "paid", true},
+		{ParamSpec{Type: TypeString}, 1.0, false},
+		{ParamSpec{Type: TypeInt}, 3.0, true},
+		{ParamSpec{Type: TypeInt}, json.Number("3"), true},
+		{ParamSpec{Type: TypeInt}, 3.5, false},
+		{ParamSpec{Type: TypeInt}, "3", false},
+		{ParamSpec{Type: TypeNumber}, 3.5, true},
+		{ParamSpec{Type: TypeBool}, true, true},
+		{ParamSpec{Type: TypeBool}, "true", false},
+		{ParamSpec{Type: TypeDate}, "2020-08-01", true},
+		{ParamSpec{Type: TypeDate}, "2020-08-01 10:00:00", false},
+		{ParamSpec{Type: TypeDateTime}, "2020-08-01 10:00:00", true},
+		{ParamSpec{Type: TypeDateTime}, "2020-08-01T10:00:00+08:00", true},
+		{ParamSpec{Type: TypeDateTime}, "yesterday", false},
+		{ParamSpec{}, "anything", true},
+		{ParamSpec{}, []interface{}{"a"}, false},
+		{ParamSpec{}, map[string]interface{}{"a": 1}, false},
+		{ParamSpec{Enum: []interface{}{"paid", "new"}}, "new", true},
+		{ParamSpec{Enum: []interface{}{"paid", "new"}}, "old", false},
+		// yaml enum entries are ints, json values are floats
+		{ParamSpec{Enum: []interface{}{1, 2}}, 2.0, true},
+		{ParamSpec{Enum: []interface{}{1, 2}}, 3.0, false},
+		{ParamSpec{Type: TypeNumber, Min: &one, Max: &ten}, 10.0, true},
+		{ParamSpec{Type: TypeNumber, Min: &one, Max: &ten}, 0.5, false},
+		{ParamSpec{Type: TypeNumber, Min: &one, Max: &ten}, 11.0, false},
+		{ParamSpec{Type: TypeString, Min: &one, Max: &ten}, "订单", true},
+		{ParamSpec{Type: TypeString, Min: &one, Max: &ten}, "", false},
+		{ParamSpec{Type: TypeString, Min: &one, Max: &ten}, "0123456789a", false},
+		{ParamSpec{Type: TypeString, Regexp: `^[A-Z]+$`}, "PAID", true},
+		{ParamSpec{Type: TypeString, Regexp: `^[A-Z]+$`}, "paid", false},
+	}
+	for _, tt := range tests {
+		spec := tt.spec
+		if err := spec.prepare(); err != nil {
+			t.Fatalf("%+v: %v", spec, err)
+		}
+		if err := spec.Check(tt.v); (err == nil) != tt.ok {
+			t.Errorf("%+v: Check(%#v) = %v, want ok %v", tt.spec, tt.v, err, tt.ok)
+		}
+	}
+}
+
+func TestValidateParams(t *testing.T) {
+	tests := []struct {
+		params string
+		ok     bool
+	}{
+		{"- name: status\n  type: string\n  required: true", true},
+		{"- name: limit\n  type: int\n  default: 10\n  min: 1\n  max: 100", true},
+		{"- name: limit\n  type: int\n  default: 1000\n  max: 100", false},
+		{"- name: status\n  enum: [paid, new]\n  default: old", false},
+		{"- name: code\n  regexp: '[a-z'", false},
+		{"- name: code\n  regexp: '^[a-z]+$'\n  default: ABC", false},
+		{"- name: status\n- name: status", false},
+		{"- name: 'status; DROP'", false},
+		{"- name: status\n  type: blob", false},
+	}
+	for _, tt := range tests {
+		content := "composition: {subject: {data: 'SELECT 1'}}\nparams:\n  " + strings.Replace(tt.params, "\n", "\n  ", -1)
+		_, err := Compile(testDocRow(content, time.Time{}))
+		if (err == nil) != tt.ok {
+			t.Errorf("%q: got %v, want ok %v", tt.params, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package composer
+
+import (
+	"fmt"
+	"github.com/wangxb07/sqlcomposer"
+	"regexp"
+	"strings"
+)
+
+const (
+	NullsFirst = "first"
+	NullsLast  = "last"
+)
+
+// identifierPattern matches a plain or table qualified column name, sort
+// fields are written into ORDER BY as is.
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)*$`)
+
+// SortSpec is one ordering of a request or of options.default_sort. Dir is
+// ASC (default) or DESC, Nulls optionally first or last.
+type SortSpec struct {
+	Field string `yaml:"field" json:"field"`
+	Dir   string `yaml:"dir,omitempty" json:"dir,omitempty"`
+	Nulls string `yaml:"nulls,omitempty" json:"nulls,omitempty"`
+}
+
+// Normalize validates s and returns it with upper case direction and lower
+// case nulls placement.
+func (s SortSpec) Normalize() (SortSpec, error) {
+	if !identifierPattern.MatchString(s.Field) {
+		return s, fmt.Errorf("invalid sort field %q", s.Field)
+	}
+
+	s.Dir = strings.ToUpper(s.Dir)
+	switch s.Dir {
+	case "":
+		s.Dir = string(sqlcomposer.ASC)
+	case string(sqlcomposer.ASC), sqlcomposer.DESC:
+	default:
+		return s, fmt.Errorf("invalid sort direction %q, expects asc or desc", s.Dir)
+	}
+
+	s.Nulls = strings.ToLower(s.Nulls)
+	switch s.Nulls {
+	case "", NullsFirst, NullsLast:
+	default:
+		return s, fmt.Errorf("invalid nulls placement %q, expects first or last", s.Nulls)
+	}
+
+	return s, nil
+}
+
+// IsSortable tells whether clients may sort on field, one of the sortable
+// list. A document without a sortable list accepts any plain or table
+// qualified column name, as before the list was declared, an empty list
+// accepts no request sorts.
+func (d *Doc) IsSortable(field string) bool {
+	if d.Sortable == nil {
+		return identifierPattern.MatchString(field)
+	}
+
+	for _, f := range d.Sortable {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// NullsTerm is 1 for the rows where expr is null and 0 for the others. Not
+// every database orders by a boolean expression.
+func NullsTerm(expr string) string {
+	return fmt.Sprintf("CASE WHEN %s IS NULL THEN 1 ELSE 0 END", expr)
+}
+
+// OrderBy turns normalized specs into the ordering of the builder. The nulls
+// placement is emulated with a leading NullsTerm.
+func (d *Doc) OrderBy(specs []SortSpec) *sqlcomposer.OrderBy {
+	ob := &sqlcomposer.OrderBy{}
+
+	for _, s := range specs {
+		switch s.Nulls {
+		case NullsFirst:
+			*ob = append(*ob, sqlcomposer.Sort{Name: NullsTerm(d.FieldExpr(s.Field)), Direction: sqlcomposer.DESC})
+		case NullsLast:
+			*ob = append(*ob, sqlcomposer.Sort{Name: NullsTerm(d.FieldExpr(s.Field)), Direction: sqlcomposer.ASC})
+		}
+		*ob = append(*ob, sqlcomposer.Sort{Name: s.Field, Direction: sqlcomposer.Direction(s.Dir)})
+	}
+
+	return ob
+}
+
+func (d *Doc) validateSorts() error {
+	for _, f := range d.Sortable {
+		if !identifierPattern.MatchString(f) {
+			return fmt.Errorf("invalid sortable field %q", f)
+		}
+	}
+
+	if c := d.Options.Cursor; c != nil {
+		for _, f := range c.Nullable {
+			if !identifierPattern.MatchString(f) {
+				return fmt.Errorf("invalid cursor nullable field %q", f)
+			}
+		}
+	}
+
+	for i, s := range d.Options.DefaultSort {
+		ns, err := s.Normalize()
+		if err != nil {
+			return fmt.Errorf("default_sort: %v", err)
+		}
+		d.Options.DefaultSort[i] = ns
+	}
+
+	return nil
+}
@@ -0,0 +1,187 @@
+package composer
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// readVerbs are the statements a doc may run without being a mutation
+var readVerbs = map[string]bool{
+	"SELECT":  true,
+	"WITH":    true,
+	"SHOW":    true,
+	"EXPLAIN": true,
+}
+
+// modifyingKeywords are the statements a WITH query may end with or hold in
+// its common table expressions besides SELECT
+var modifyingKeywords = map[string]bool{
+	"INSERT": true,
+	"UPDATE": true,
+	"DELETE": true,
+	"MERGE":  true,
+}
+
+// IsRead tells whether query starts with a read verb. A WITH query is a read
+// only when none of its statements modifies data.
+func IsRead(query string) bool {
+	verb := StatementVerb(query)
+	if verb == "WITH" {
+		return !modifiesData(query)
+	}
+	return readVerbs[verb]
+}
+
+// modifiesData tells whether query holds a data modifying keyword outside of
+// quotes and comments. A FOR UPDATE locking clause counts as one.
+func modifiesData(query string) bool {
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			i = skipQuoted(query, i)
+		case c == '[':
+			// a bracket quoted identifier of SQL Server
+			if n := strings.IndexByte(query[i:], ']'); n >= 0 {
+				i += n + 1
+			} else {
+				i = len(query)
+			}
+		case isWordByte(c):
+			j := i
+			for j < len(query) && isWordByte(query[j]) {
+				j++
+			}
+			if modifyingKeywords[strings.ToUpper(query[i:j])] {
+				return true
+			}
+			i = j
+		default:
+			if n := skipComment(query, i); n > i {
+				i = n
+			} else {
+				i++
+			}
+		}
+	}
+	return false
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c == '$' || c >= 0x80 || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c))
+}
+
+// StatementVerb returns the first keyword of query upper cased. Comments and
+// opening parentheses in front of it are skipped, an executable /*! comment
+// is not a comment.
+func StatementVerb(query string) string {
+	i := skipSpace(query, 0)
+	for i < len(query) && query[i] == '(' {
+		i = skipSpace(query, i+1)
+	}
+
+	j := i
+	for j < len(query) && (unicode.IsLetter(rune(query[j])) || query[j] == '_') {
+		j++
+	}
+
+	return strings.ToUpper(query[i:j])
+}
+
+// CheckStatement rejects a built statement the doc may not run: anything but
+// a read unless mutation is set, and more than one statement in any case.
+func CheckStatement(query string, mutation bool) error {
+	verb := StatementVerb(query)
+	if verb == "" {
+		return fmt.Errorf("statement has no verb")
+	}
+	if !mutation && !IsRead(query) {
+		if verb == "WITH" {
+			return fmt.Errorf("WITH statement modifies data, the doc is not a mutation")
+		}
+		return fmt.Errorf("%s statement is not allowed, the doc is not a mutation", verb)
+	}
+	if end := statementEnd(query); skipSpace(query, end) < len(query) {
+		return fmt.Errorf("multiple statements are not allowed")
+	}
+	return nil
+}
+
+// statementEnd returns the index of the first semicolon outside of quotes and
+// comments, or the length of query.
+func statementEnd(query string) int {
+	for i := 0; i < len(query); {
+		switch c := query[i]; c {
+		case '\'', '"', '`':
+			i = skipQuoted(query, i)
+		case ';':
+			return i
+		default:
+			if n := skipComment(query, i); n > i {
+				i = n
+			} else {
+				i++
+			}
+		}
+	}
+	return len(query)
+}
+
+// skipQuoted returns the index after the quoted string starting at i, quotes
+// are escaped by doubling them or with a backslash.
+func skipQuoted(query string, i int) int {
+	q := query[i]
+	for i++; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			if q != '`' {
+				i++
+			}
+		case q:
+			if i+1 < len(query) && query[i+1] == q {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(query)
+}
+
+// skipSpace returns the index of the first byte from i that is neither space
+// nor part of a comment. A trailing semicolon counts as space.
+func skipSpace(query string, i int) int {
+	for i < len(query) {
+		if unicode.IsSpace(rune(query[i])) || query[i] == ';' {
+			i++
+			continue
+		}
+		n := skipComment(query, i)
+		if n == i {
+			break
+		}
+		i = n
+	}
+	return i
+}
+
+// skipComment returns the index after the comment starting at i, or i when
+// there is none.
+func skipComment(query string, i int) int {
+	rest := query[i:]
+	switch {
+	case strings.HasPrefix(rest, "#"),
+		strings.HasPrefix(rest, "--") && (len(rest) == 2 || unicode.IsSpace(rune(rest[2]))):
+		if n := strings.IndexByte(rest, '\n'); n >= 0 {
+			return i + n + 1
+		}
+		return len(query)
+	case strings.HasPrefix(rest, "/*") && !strings.HasPrefix(rest, "/*!"):
+		if n := strings.Index(rest[2:], "*/"); n >= 0 {
+			return i + 2 + n + 2
+		}
+		return len(query)
+	}
+	return i
+}
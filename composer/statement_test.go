@@ -0,0 +1,60 @@
+package composer
+
+import "testing"
+
+func TestIsRead(t *testing.T) {
+	tests := []struct {
+		query string
+		read  bool
+	}{
+		{"SELECT * FROM orders", true},
+		{"  /* list */ (SELECT id FROM orders)", true},
+		{"-- orders\nSELECT id FROM orders", true},
+		{"SHOW TABLES", true},
+		{"EXPLAIN SELECT 1", true},
+		{"WITH o AS (SELECT id FROM orders) SELECT * FROM o", true},
+		{"WITH o AS (SELECT updated_at, deleted FROM orders) SELECT * FROM o", true},
+		{"WITH o AS (SELECT 'update' AS verb, \"delete\" FROM orders) SELECT * FROM o", true},
+		{"WITH o AS (SELECT [insert] FROM orders) SELECT * FROM o -- delete", true},
+		{"WITH o AS (SELECT id FROM orders /* merge */) SELECT * FROM o", true},
+		{"WITH d AS (DELETE FROM orders RETURNING id) SELECT * FROM d", false},
+		{"WITH o AS (SELECT id FROM orders) UPDATE orders SET note = 'x'", false},
+		{"with i as (insert into orders (id) values (1) returning id) select * from i", false},
+		{"WITH s AS (SELECT 1 AS id) MERGE INTO orders USING s ON orders.id = s.id WHEN MATCHED THEN DELETE", false},
+		{"INSERT INTO orders (id) VALUES (1)", false},
+		{"UPDATE orders SET note = 'x'", false},
+		{"DELETE FROM orders", false},
+		{"/*!SELECT */ DELETE FROM orders", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := IsRead(tt.query); got != tt.read {
+			t.Errorf("IsRead(%q) = %v, want %v", tt.query, got, tt.read)
+		}
+	}
+}
+
+func TestCheckStatement(t *testing.T) {
+	tests := []struct {
+		query    string
+		mutation bool
+		ok       bool
+	}{
+		{"SELECT 1", false, true},
+		{"SELECT 1;", false, true},
+		{"SELECT 1; DELETE FROM orders", false, false},
+		{"SELECT ';' FROM orders", false, true},
+		{"WITH d AS (DELETE FROM orders RETURNING id) SELECT * FROM d", false, false},
+		{"WITH d AS (DELETE FROM orders RETURNING id) SELECT * FROM d", true, true},
+		{"UPDATE orders SET note = 'x'", false, false},
+		{"UPDATE orders SET note = 'x'", true, true},
+		{"-- nothing", true, false},
+	}
+
+	for _, tt := range tests {
+		if err := CheckStatement(tt.query, tt.mutation); (err == nil) != tt.ok {
+			t.Errorf("CheckStatement(%q, %v) = %v, want ok %v", tt.query, tt.mutation, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package composer
+
+import "fmt"
+
+// DefaultSuggestLimit is the number of suggestions when neither the request
+// nor the doc sets one
+const DefaultSuggestLimit = 10
+
+// SuggestSpec makes the result column Field of the data subject suggestible.
+// Attr is the expression the prefix is matched against, its filters are left
+// out of the request filters when suggesting.
+type SuggestSpec struct {
+	Field string `yaml:"field"`
+	Attr  string `yaml:"attr,omitempty"`
+	Limit int    `yaml:"limit,omitempty"`
+}
+
+// SuggestSpec looks the suggestion settings of field up
+func (d *Doc) SuggestSpec(field string) (*SuggestSpec, bool) {
+	for i := range d.Suggest {
+		if d.Suggest[i].Field == field {
+			return &d.Suggest[i], true
+		}
+	}
+	return nil, false
+}
+
+func (d *Doc) validateSuggest() error {
+	seen := make(map[string]bool, len(d.Suggest))
+
+	for i := range d.Suggest {
+		ss := &d.Suggest[i]
+
+		if !paramNamePattern.MatchString(ss.Field) {
+			return fmt.Errorf("invalid suggest field %q", ss.Field)
+		}
+		if seen[ss.Field] {
+			return fmt.Errorf("suggest %s is declared twice", ss.Field)
+		}
+		seen[ss.Field] = true
+
+		if ss.Attr == "" {
+			ss.Attr = ss.Field
+		}
+		if !identifierPattern.MatchString(ss.Attr) {
+			return fmt.Errorf("suggest %s: invalid attr %q", ss.Field, ss.Attr)
+		}
+		if ss.Limit <= 0 {
+			ss.Limit = DefaultSuggestLimit
+		}
+	}
+
+	return nil
+}
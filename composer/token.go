@@ -0,0 +1,262 @@
+package composer
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/friendsofgo/errors"
+	"github.com/user/sqlcomposer-svc/models"
+	"github.com/wangxb07/sqlcomposer"
+	"gopkg.in/yaml.v2"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// TypeIdent is the type of the token params naming a table or a column, they
+// are quoted as identifiers rather than as literals
+const TypeIdent = "ident"
+
+// reservedTokens are the tokens of sqlcomposer and of the service, a stored
+// token can not take their names
+var reservedTokens = map[string]bool{
+	"where":        true,
+	"having":       true,
+	"limit":        true,
+	"order_by":     true,
+	"attrs":        true,
+	"attrs_fields": true,
+}
+
+// IsReservedToken tells whether name is a token of sqlcomposer or of the service.
+func IsReservedToken(name string) bool {
+	return reservedTokens[name]
+}
+
+// TokenParamSpec declares a param of a stored token. The value a doc gives is
+// checked like the value of a doc param and, when Lookup is set, replaced by
+// the first column of the row Lookup reads for it.
+type TokenParamSpec struct {
+	ParamSpec `yaml:",inline"`
+	// Lookup is a read with a single placeholder bound to the value, e.g.
+	// SELECT sid FROM fty_dictionary_type WHERE code = ?
+	Lookup string `yaml:"lookup,omitempty"`
+}
+
+// Token is a compiled row of the token table. Its template is a text/template
+// rendered with the params of a doc, as SQL of the datasource, e.g.
+// LEFT JOIN fty_obj_attr AS a ON a.attr_sid = {{.sid}}
+type Token struct {
+	Name      string
+	DBName    string
+	UpdatedAt time.Time
+	Params    []TokenParamSpec
+
+	tmpl *template.Template
+}
+
+func CompileToken(t *models.Token) (*Token, error) {
+	if !paramNamePattern.MatchString(t.Name) {
+		return nil, fmt.Errorf("invalid token name %q", t.Name)
+	}
+	if IsReservedToken(t.Name) {
+		return nil, fmt.Errorf("token name %s is reserved", t.Name)
+	}
+
+	var params []TokenParamSpec
+	if err := yaml.Unmarshal([]byte(t.Params.String), &params); err != nil {
+		return nil, errors.Wrap(err, "token params parse failure")
+	}
+
+	seen := make(map[string]bool, len(params))
+	for i := range params {
+		ps := &params[i]
+
+		if !paramNamePattern.MatchString(ps.Name) {
+			return nil, fmt.Errorf("invalid param name %q", ps.Name)
+		}
+		if seen[ps.Name] {
+			return nil, fmt.Errorf("param %s is declared twice", ps.Name)
+		}
+		seen[ps.Name] = true
+
+		if ps.Type != "" && ps.Type != TypeIdent && !isValueType(ps.Type) {
+			return nil, fmt.Errorf("param %s: unknown type %s", ps.Name, ps.Type)
+		}
+
+		if err := ps.prepare(); err != nil {
+			return nil, fmt.Errorf("param %s: %v", ps.Name, err)
+		}
+
+		if ps.Lookup != "" {
+			if !IsRead(ps.Lookup) {
+				return nil, fmt.Errorf("param %s: lookup is not a read", ps.Name)
+			}
+			if err := CheckStatement(ps.Lookup, false); err != nil {
+				return nil, fmt.Errorf("param %s: lookup: %v", ps.Name, err)
+			}
+		}
+	}
+
+	// the statement a token is put in is scanned for tokens again
+	if strings.Contains(t.Template, "%") {
+		return nil, fmt.Errorf("token template contains %%, pass LIKE patterns as string params")
+	}
+
+	tmpl, err := template.New(t.Name).Option("missingkey=zero").Parse(t.Template)
+	if err != nil {
+		return nil, errors.Wrap(err, "token template parse failure")
+	}
+
+	return &Token{
+		Name:      t.Name,
+		DBName:    t.DBName,
+		UpdatedAt: t.UpdatedAt.Time,
+		Params:    params,
+		tmpl:      tmpl,
+	}, nil
+}
+
+// Param looks the param called name up.
+func (t *Token) Param(name string) (*TokenParamSpec, bool) {
+	for i := range t.Params {
+		if t.Params[i].Name == name {
+			return &t.Params[i], true
+		}
+	}
+	return nil, false
+}
+
+// Bind checks the params a doc gives the token and adds the defaults of the
+// missing ones. The values of the doc are strings, they are converted to the
+// type of their param first.
+func (t *Token) Bind(params []sqlcomposer.TokenParam) (map[string]interface{}, error) {
+	values := make(map[string]interface{}, len(t.Params))
+
+	for _, p := range params {
+		ps, ok := t.Param(p.Name)
+		if !ok {
+			return nil, fmt.Errorf("unknown param %s", p.Name)
+		}
+		if _, ok := values[p.Name]; ok {
+			return nil, fmt.Errorf("param %s is given twice", p.Name)
+		}
+
+		v, err := tokenValue(ps.Type, p.Value)
+		if err != nil {
+			return nil, fmt.Errorf("param %s: %v", p.Name, err)
+		}
+		if err := ps.Check(v); err != nil {
+			return nil, fmt.Errorf("param %s: %v", p.Name, err)
+		}
+		values[p.Name] = v
+	}
+
+	for _, ps := range t.Params {
+		if _, ok := values[ps.Name]; ok {
+			continue
+		}
+		if ps.Default != nil {
+			values[ps.Name] = ps.Default
+		} else if ps.Required {
+			return nil, fmt.Errorf("param %s is required", ps.Name)
+		}
+	}
+
+	return values, nil
+}
+
+// tokenValue converts the string a doc gives for a param of type typ
+func tokenValue(typ string, s string) (interface{}, error) {
+	switch typ {
+	case TypeInt:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("value %s is not a %s", s, typ)
+		}
+		return n, nil
+	case TypeNumber:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("value %s is not a %s", s, typ)
+		}
+		return n, nil
+	case TypeBool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("value %s is not a %s", s, typ)
+		}
+		return b, nil
+	}
+	return s, nil
+}
+
+// Render executes the template with the SQL of the values of the params, e.g.
+// quoted identifiers or named placeholders. Colons of the template text are
+// escaped as :: so that binding the statement does not take them for
+// placeholders, the values are put in as they are.
+func (t *Token) Render(values map[string]string) (string, error) {
+	markers := make(map[string]string, len(values))
+	var replace []string
+	for name, v := range values {
+		m := fmt.Sprintf("\x00%d\x00", len(replace)/2)
+		markers[name] = m
+		replace = append(replace, m, v)
+	}
+
+	var b bytes.Buffer
+	if err := t.tmpl.Execute(&b, markers); err != nil {
+		return "", err
+	}
+
+	text := strings.Replace(b.String(), ":", "::", -1)
+	return strings.NewReplacer(replace...).Replace(text), nil
+}
+
+// TokenCache keeps compiled tokens by datasource and name. An entry is
+// recompiled when the updated_at of the row no longer matches or after it has
+// been invalidated.
+type TokenCache struct {
+	mu     sync.RWMutex
+	tokens map[string]*Token
+}
+
+func NewTokenCache() *TokenCache {
+	return &TokenCache{
+		tokens: make(map[string]*Token),
+	}
+}
+
+func tokenKey(dbName, name string) string {
+	return dbName + "/" + name
+}
+
+func (c *TokenCache) Get(t *models.Token) (*Token, error) {
+	key := tokenKey(t.DBName, t.Name)
+
+	c.mu.RLock()
+	ct, ok := c.tokens[key]
+	c.mu.RUnlock()
+
+	if ok && ct.UpdatedAt.Equal(t.UpdatedAt.Time) {
+		return ct, nil
+	}
+
+	ct, err := CompileToken(t)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.tokens[key] = ct
+	c.mu.Unlock()
+
+	return ct, nil
+}
+
+func (c *TokenCache) Invalidate(dbName, name string) {
+	c.mu.Lock()
+	delete(c.tokens, tokenKey(dbName, name))
+	c.mu.Unlock()
+}
@@ -0,0 +1,115 @@
+package composer
+
+import (
+	"github.com/user/sqlcomposer-svc/models"
+	"github.com/volatiletech/null/v8"
+	"github.com/wangxb07/sqlcomposer"
+	"reflect"
+	"testing"
+)
+
+const testTokenParams = `
+- name: status
+  type: string
+  enum: [paid, new]
+  required: true
+- name: col
+  type: ident
+  default: amount
+- name: min
+  type: number
+  default: 0
+- name: id
+  type: int
+`
+
+func testToken(name, template, params string) *models.Token {
+	return &models.Token{Name: name, DBName: "lite", Template: template, Params: null.StringFrom(params)}
+}
+
+func TestCompileToken(t *testing.T) {
+	if _, err := CompileToken(testToken("status_is", "orders.status = {{.status}}", testTokenParams)); err != nil {
+		t.Fatal(err)
+	}
+
+	bad := []*models.Token{
+		testToken("where", "1 = 1", ""),
+		testToken("status-is", "1 = 1", ""),
+		testToken("status_is", "{{.status", testTokenParams),
+		testToken("status_is", "note LIKE 'a%' AND status = {{.status}}", testTokenParams),
+		testToken("status_is", "%where", ""),
+		testToken("status_is", "1 = 1", "- name: a\n  type: blob"),
+		testToken("status_is", "1 = 1", "- name: a\n- name: a"),
+		testToken("status_is", "1 = 1", "- name: a\n  lookup: DELETE FROM orders WHERE id = ?"),
+		testToken("status_is", "1 = 1", "- name: a\n  lookup: SELECT sid FROM t WHERE code = ?; DELETE FROM t"),
+	}
+	for _, tok := range bad {
+		if _, err := CompileToken(tok); err == nil {
+			t.Errorf("CompileToken(%q, %q, %q) succeeded", tok.Name, tok.Template, tok.Params.String)
+		}
+	}
+}
+
+func TestTokenBind(t *testing.T) {
+	tok, err := CompileToken(testToken("status_is", "", testTokenParams))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values, err := tok.Bind([]sqlcomposer.TokenParam{
+		{Name: "status", Value: "paid"}, {Name: "min", Value: "10.5"}, {Name: "id", Value: "9007199254740993"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"status": "paid", "col": "amount", "min": 10.5, "id": int64(9007199254740993)}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("got %v, want %v", values, want)
+	}
+
+	bad := [][]sqlcomposer.TokenParam{
+		{},
+		{{Name: "status", Value: "x' OR 1=1 --"}},
+		{{Name: "status", Value: "paid"}, {Name: "min", Value: "1;DROP"}},
+		{{Name: "status", Value: "paid"}, {Name: "id", Value: "1.5"}},
+		{{Name: "status", Value: "paid"}, {Name: "id", Value: "1e3"}},
+		{{Name: "status", Value: "paid"}, {Name: "other", Value: "1"}},
+		{{Name: "status", Value: "paid"}, {Name: "status", Value: "new"}},
+	}
+	for _, params := range bad {
+		if values, err := tok.Bind(params); err == nil {
+			t.Errorf("Bind(%v) = %v, want an error", params, values)
+		}
+	}
+}
+
+func TestTokenRender(t *testing.T) {
+	tok, err := CompileToken(testToken("recent",
+		"created_at > '2020-01-01 12:30:00' AND {{.col}} = {{.status}}{{if .note}} AND note::text = {{.note}}{{end}}", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		values map[string]string
+		want   string
+	}{
+		{
+			map[string]string{"col": `"status"`, "status": ":tokens.1"},
+			`created_at > '2020-01-01 12::30::00' AND "status" = :tokens.1`,
+		},
+		{
+			map[string]string{"col": "`status`", "status": ":tokens.1", "note": ":tokens.2"},
+			"created_at > '2020-01-01 12::30::00' AND `status` = :tokens.1 AND note::::text = :tokens.2",
+		},
+	}
+	for _, tt := range tests {
+		got, err := tok.Render(tt.values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Render(%v) = %q, want %q", tt.values, got, tt.want)
+		}
+	}
+}
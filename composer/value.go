@@ -0,0 +1,93 @@
+package composer
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Value types filters and params can declare
+const (
+	TypeString   = "string"
+	TypeInt      = "int"
+	TypeNumber   = "number"
+	TypeBool     = "bool"
+	TypeDate     = "date"
+	TypeDateTime = "datetime"
+)
+
+func isValueType(typ string) bool {
+	switch typ {
+	case TypeString, TypeInt, TypeNumber, TypeBool, TypeDate, TypeDateTime:
+		return true
+	}
+	return false
+}
+
+// CheckValue checks a scalar value of the request json or of the document
+// yaml against typ, an empty type accepts any scalar.
+func CheckValue(typ string, v interface{}) error {
+	switch v.(type) {
+	case nil:
+		return fmt.Errorf("value is null")
+	case []interface{}, map[string]interface{}, map[interface{}]interface{}:
+		return fmt.Errorf("value must be a scalar")
+	}
+
+	switch typ {
+	case TypeString:
+		if _, ok := v.(string); ok {
+			return nil
+		}
+	case TypeInt:
+		if n, ok := Number(v); ok && n == math.Trunc(n) {
+			return nil
+		}
+	case TypeNumber:
+		if _, ok := Number(v); ok {
+			return nil
+		}
+	case TypeBool:
+		if _, ok := v.(bool); ok {
+			return nil
+		}
+	case TypeDate:
+		if s, ok := v.(string); ok {
+			if _, err := time.Parse("2006-01-02", s); err == nil {
+				return nil
+			}
+		}
+	case TypeDateTime:
+		if s, ok := v.(string); ok {
+			if _, err := time.Parse("2006-01-02 15:04:05", s); err == nil {
+				return nil
+			}
+			if _, err := time.Parse(time.RFC3339, s); err == nil {
+				return nil
+			}
+		}
+	default:
+		return nil
+	}
+
+	return fmt.Errorf("value %v is not a %s", v, typ)
+}
+
+// Number reads a json (float64, json.Number) or yaml (int, float64) number
+func Number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
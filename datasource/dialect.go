@@ -0,0 +1,213 @@
+package datasource
+
+import (
+	"fmt"
+	_ "github.com/denisenkom/go-mssqldb"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Drivers of the datasources, the database/sql driver names
+const (
+	DriverMySQL     = "mysql"
+	DriverPostgres  = "postgres"
+	DriverSQLite    = "sqlite3"
+	DriverSQLServer = "sqlserver"
+)
+
+// Dialect is what the statements of the service need to know about the
+// database behind a driver. Placeholders are rebound by sqlx from the driver
+// name of the pool.
+type Dialect interface {
+	Driver() string
+	// Limit renders the clause keeping size rows after offset. ordered tells
+	// whether the statement already has an ORDER BY.
+	Limit(offset, size int64, ordered bool) string
+	// ConnectionID is the statement reading the server id of a connection,
+	// empty when the driver cancels statements on the server by itself
+	ConnectionID() string
+	// Kill is the statement cancelling what connection id runs, only used
+	// along with ConnectionID
+	Kill(id int64) string
+	// Begin starts a read only transaction, with snapshot one whose reads all
+	// see the same snapshot
+	Begin(snapshot bool) []string
+	// End ends the transaction started by Begin and resets the connection
+	End(snapshot bool) []string
+	// FoldIdent is name as the database stores it when it is not quoted, so
+	// that a quoted name matches the unquoted uses of it
+	FoldIdent(name string) string
+	// QuoteIdent quotes name as an identifier, QuoteIdent of the package
+	// validates and folds it first
+	QuoteIdent(name string) string
+}
+
+var dialects = map[string]Dialect{
+	DriverMySQL:     mysqlDialect{},
+	DriverPostgres:  postgresDialect{},
+	DriverSQLite:    sqliteDialect{},
+	DriverSQLServer: sqlserverDialect{},
+}
+
+// DialectOf returns the dialect of driver, an empty driver is MySQL.
+func DialectOf(driver string) (Dialect, error) {
+	if driver == "" {
+		driver = DriverMySQL
+	}
+	if d, ok := dialects[driver]; ok {
+		return d, nil
+	}
+	return nil, fmt.Errorf("unsupported driver %s", driver)
+}
+
+// DialectFor returns the dialect of the driver db was opened with.
+func DialectFor(db *sqlx.DB) (Dialect, error) {
+	return DialectOf(db.DriverName())
+}
+
+// mysqlLimit is the clause sqlcomposer renders for %limit
+var mysqlLimit = regexp.MustCompile(`(?i)\bLIMIT (\d+), (\d+)`)
+
+// RewriteLimit turns the MySQL limit clauses of a built statement into the
+// ones of d.
+func RewriteLimit(d Dialect, query string) string {
+	if d.Driver() == DriverMySQL {
+		return query
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, m := range mysqlLimit.FindAllStringSubmatchIndex(query, -1) {
+		offset, err1 := strconv.ParseInt(query[m[2]:m[3]], 10, 64)
+		size, err2 := strconv.ParseInt(query[m[4]:m[5]], 10, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+
+		b.WriteString(query[last:m[0]])
+		b.WriteString(d.Limit(offset, size, ordered(query[:m[0]])))
+		last = m[1]
+	}
+	b.WriteString(query[last:])
+
+	return b.String()
+}
+
+// ordered tells whether the statement ending with prefix ends with an ORDER
+// BY clause of its own, not one of a subquery.
+func ordered(prefix string) bool {
+	upper := strings.ToUpper(prefix)
+	i := strings.LastIndex(upper, "ORDER BY")
+	if i < 0 || i < strings.LastIndex(upper, " FROM ") {
+		return false
+	}
+	rest := upper[i:]
+	return strings.Count(rest, "(") == strings.Count(rest, ")")
+}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) Driver() string { return DriverMySQL }
+
+func (mysqlDialect) Limit(offset, size int64, ordered bool) string {
+	return fmt.Sprintf("LIMIT %d, %d", offset, size)
+}
+
+func (mysqlDialect) ConnectionID() string { return "SELECT CONNECTION_ID()" }
+
+func (mysqlDialect) Kill(id int64) string { return fmt.Sprintf("KILL QUERY %d", id) }
+
+func (mysqlDialect) Begin(snapshot bool) []string {
+	if snapshot {
+		return []string{"START TRANSACTION WITH CONSISTENT SNAPSHOT, READ ONLY"}
+	}
+	return []string{"START TRANSACTION READ ONLY"}
+}
+
+func (mysqlDialect) End(snapshot bool) []string { return []string{"ROLLBACK"} }
+
+type postgresDialect struct{}
+
+func (postgresDialect) Driver() string { return DriverPostgres }
+
+func (postgresDialect) Limit(offset, size int64, ordered bool) string {
+	return fmt.Sprintf("LIMIT %d OFFSET %d", size, offset)
+}
+
+// lib/pq sends a cancel request when the context of a statement ends
+func (postgresDialect) ConnectionID() string { return "" }
+
+func (postgresDialect) Kill(id int64) string { return "" }
+
+func (postgresDialect) Begin(snapshot bool) []string {
+	if snapshot {
+		return []string{"BEGIN ISOLATION LEVEL REPEATABLE READ, READ ONLY"}
+	}
+	return []string{"BEGIN READ ONLY"}
+}
+
+func (postgresDialect) End(snapshot bool) []string { return []string{"ROLLBACK"} }
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Driver() string { return DriverSQLite }
+
+func (sqliteDialect) Limit(offset, size int64, ordered bool) string {
+	return fmt.Sprintf("LIMIT %d OFFSET %d", size, offset)
+}
+
+// go-sqlite3 interrupts the statement when its context ends
+func (sqliteDialect) ConnectionID() string { return "" }
+
+func (sqliteDialect) Kill(id int64) string { return "" }
+
+// SQLite has no read only transactions, query_only refuses writes on the
+// connection instead. A deferred transaction reads a single snapshot.
+func (sqliteDialect) Begin(snapshot bool) []string {
+	return []string{"PRAGMA query_only = ON", "BEGIN"}
+}
+
+func (sqliteDialect) End(snapshot bool) []string {
+	return []string{"ROLLBACK", "PRAGMA query_only = OFF"}
+}
+
+type sqlserverDialect struct{}
+
+func (sqlserverDialect) Driver() string { return DriverSQLServer }
+
+// OFFSET FETCH needs an ORDER BY
+func (sqlserverDialect) Limit(offset, size int64, ordered bool) string {
+	clause := fmt.Sprintf("OFFSET %d ROWS FETCH NEXT %d ROWS ONLY", offset, size)
+	if !ordered {
+		clause = "ORDER BY (SELECT NULL) " + clause
+	}
+	return clause
+}
+
+// go-mssqldb sends an attention when the context of a statement ends
+func (sqlserverDialect) ConnectionID() string { return "" }
+
+func (sqlserverDialect) Kill(id int64) string { return "" }
+
+// SQL Server has no read only transactions, this is a plain transaction that
+// does not refuse writes. Writes are kept out by CheckStatement before a
+// statement is sent, End rolls the transaction back so that none would be
+// kept. The snapshot isolation level needs ALLOW_SNAPSHOT_ISOLATION on the
+// database.
+func (sqlserverDialect) Begin(snapshot bool) []string {
+	if snapshot {
+		return []string{"SET TRANSACTION ISOLATION LEVEL SNAPSHOT", "BEGIN TRANSACTION"}
+	}
+	return []string{"BEGIN TRANSACTION"}
+}
+
+func (sqlserverDialect) End(snapshot bool) []string {
+	if snapshot {
+		return []string{"ROLLBACK", "SET TRANSACTION ISOLATION LEVEL READ COMMITTED"}
+	}
+	return []string{"ROLLBACK"}
+}
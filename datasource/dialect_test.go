@@ -0,0 +1,145 @@
+package datasource
+
+import (
+	"context"
+	"github.com/jmoiron/sqlx"
+	"reflect"
+	"testing"
+)
+
+func TestRewriteLimit(t *testing.T) {
+	tests := []struct {
+		driver string
+		query  string
+		want   string
+	}{
+		{DriverMySQL, "SELECT id FROM orders LIMIT 10, 5", "SELECT id FROM orders LIMIT 10, 5"},
+		{DriverPostgres, "SELECT id FROM orders LIMIT 10, 5", "SELECT id FROM orders LIMIT 5 OFFSET 10"},
+		{DriverSQLite, "SELECT id FROM orders ORDER BY id limit 0, 20", "SELECT id FROM orders ORDER BY id LIMIT 20 OFFSET 0"},
+		{DriverSQLServer, "SELECT id FROM orders ORDER BY id LIMIT 10, 5",
+			"SELECT id FROM orders ORDER BY id OFFSET 10 ROWS FETCH NEXT 5 ROWS ONLY"},
+		{DriverSQLServer, "SELECT id FROM orders LIMIT 10, 5",
+			"SELECT id FROM orders ORDER BY (SELECT NULL) OFFSET 10 ROWS FETCH NEXT 5 ROWS ONLY"},
+		// the ORDER BY of a subquery does not order the outer statement
+		{DriverSQLServer, "SELECT id FROM (SELECT id FROM orders ORDER BY id) AS o LIMIT 0, 5",
+			"SELECT id FROM (SELECT id FROM orders ORDER BY id) AS o ORDER BY (SELECT NULL) OFFSET 0 ROWS FETCH NEXT 5 ROWS ONLY"},
+		{DriverPostgres, "SELECT id FROM (SELECT id FROM orders LIMIT 0, 100) AS o LIMIT 0, 5",
+			"SELECT id FROM (SELECT id FROM orders LIMIT 100 OFFSET 0) AS o LIMIT 5 OFFSET 0"},
+		{DriverPostgres, "SELECT id FROM orders", "SELECT id FROM orders"},
+	}
+
+	for _, tt := range tests {
+		d, err := DialectOf(tt.driver)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := RewriteLimit(d, tt.query); got != tt.want {
+			t.Errorf("%s: RewriteLimit(%q) = %q, want %q", tt.driver, tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestBeginEnd(t *testing.T) {
+	tests := []struct {
+		driver   string
+		snapshot bool
+		begin    []string
+		end      []string
+	}{
+		{DriverMySQL, false, []string{"START TRANSACTION READ ONLY"}, []string{"ROLLBACK"}},
+		{DriverMySQL, true, []string{"START TRANSACTION WITH CONSISTENT SNAPSHOT, READ ONLY"}, []string{"ROLLBACK"}},
+		{DriverPostgres, false, []string{"BEGIN READ ONLY"}, []string{"ROLLBACK"}},
+		{DriverPostgres, true, []string{"BEGIN ISOLATION LEVEL REPEATABLE READ, READ ONLY"}, []string{"ROLLBACK"}},
+		{DriverSQLServer, false, []string{"BEGIN TRANSACTION"}, []string{"ROLLBACK"}},
+		{DriverSQLServer, true,
+			[]string{"SET TRANSACTION ISOLATION LEVEL SNAPSHOT", "BEGIN TRANSACTION"},
+			[]string{"ROLLBACK", "SET TRANSACTION ISOLATION LEVEL READ COMMITTED"}},
+	}
+
+	for _, tt := range tests {
+		d, err := DialectOf(tt.driver)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := d.Begin(tt.snapshot); !reflect.DeepEqual(got, tt.begin) {
+			t.Errorf("%s: Begin(%v) = %q, want %q", tt.driver, tt.snapshot, got, tt.begin)
+		}
+		if got := d.End(tt.snapshot); !reflect.DeepEqual(got, tt.end) {
+			t.Errorf("%s: End(%v) = %q, want %q", tt.driver, tt.snapshot, got, tt.end)
+		}
+	}
+}
+
+// testSQLite opens the SQLite database of a test config with an orders table
+func testSQLite(t *testing.T) (*sqlx.DB, func()) {
+	dbc, cleanup := testConfig(t, "lite")
+
+	db, err := sqlx.Connect(DriverSQLite, dbc.DSN.String)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("CREATE TABLE orders (id INTEGER PRIMARY KEY); INSERT INTO orders VALUES (1), (2), (3), (4), (5)"); err != nil {
+		db.Close()
+		cleanup()
+		t.Fatal(err)
+	}
+
+	return db, func() {
+		db.Close()
+		cleanup()
+	}
+}
+
+func TestSQLiteLimit(t *testing.T) {
+	db, cleanup := testSQLite(t)
+	defer cleanup()
+
+	var ids []int
+	query := RewriteLimit(sqliteDialect{}, "SELECT id FROM orders ORDER BY id LIMIT 1, 2")
+	if err := db.Select(&ids, query); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []int{2, 3}) {
+		t.Errorf("%s: got %v", query, ids)
+	}
+}
+
+func TestSQLiteBeginRefusesWrites(t *testing.T) {
+	db, cleanup := testSQLite(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	conn, err := db.DB.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	d := sqliteDialect{}
+	for _, snapshot := range []bool{false, true} {
+		for _, q := range d.Begin(snapshot) {
+			if _, err := conn.ExecContext(ctx, q); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var n int
+		if err := conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM orders").Scan(&n); err != nil || n != 5 {
+			t.Errorf("read in transaction: %d %v", n, err)
+		}
+		if _, err := conn.ExecContext(ctx, "DELETE FROM orders"); err == nil {
+			t.Errorf("write accepted after Begin(%v)", snapshot)
+		}
+
+		for _, q := range d.End(snapshot) {
+			if _, err := conn.ExecContext(ctx, q); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if _, err := conn.ExecContext(ctx, "DELETE FROM orders WHERE id = 5"); err != nil {
+		t.Errorf("write refused after End: %v", err)
+	}
+}
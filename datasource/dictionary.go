@@ -0,0 +1,100 @@
+package datasource
+
+import (
+	"context"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"sync"
+	"time"
+)
+
+// dictionaryQuery loads the active types of the MES dictionary of a source
+const dictionaryQuery = "SELECT code, sid FROM fty_dictionary_type WHERE is_delete = ? AND status = ?"
+
+const dictTypeNotDeleted = 0
+
+// dictionary is the types of one source by code, loaded from db at loadedAt
+type dictionary struct {
+	types    map[string]string
+	db       *sqlx.DB
+	loadedAt time.Time
+}
+
+// Dictionaries caches the dictionary types of every source by name. An entry
+// is reloaded once it is older than the ttl, after it has been invalidated or
+// when the pool of its source was rebuilt.
+type Dictionaries struct {
+	ttl     time.Duration
+	enabled int
+
+	mu    sync.Mutex
+	dicts map[string]*dictionary
+	// gens counts the invalidations of every source, a load started before
+	// the last one is not cached
+	gens map[string]uint64
+}
+
+// NewDictionaries returns an empty cache of the types whose status is
+// enabled, a ttl of 0 keeps the entries until they are invalidated.
+func NewDictionaries(ttl time.Duration, enabled int) *Dictionaries {
+	return &Dictionaries{
+		ttl:     ttl,
+		enabled: enabled,
+		dicts:   make(map[string]*dictionary),
+		gens:    make(map[string]uint64),
+	}
+}
+
+// Types returns the sid of the enabled dictionary types of s by code. The
+// returned map must be treated as read only.
+func (d *Dictionaries) Types(ctx context.Context, s *Source) (map[string]string, error) {
+	d.mu.Lock()
+	dict, ok := d.dicts[s.Name]
+	gen := d.gens[s.Name]
+	d.mu.Unlock()
+
+	if ok && dict.db == s.DB && (d.ttl <= 0 || time.Since(dict.loadedAt) < d.ttl) {
+		return dict.types, nil
+	}
+
+	var rows []struct {
+		Code string `db:"code"`
+		SID  string `db:"sid"`
+	}
+	err := s.DB.SelectContext(ctx, &rows, s.DB.Rebind(dictionaryQuery), dictTypeNotDeleted, d.enabled)
+	if err != nil {
+		return nil, fmt.Errorf("dictionary of datasource %s: %v", s.Name, err)
+	}
+
+	dict = &dictionary{
+		types:    make(map[string]string, len(rows)),
+		db:       s.DB,
+		loadedAt: time.Now(),
+	}
+	for _, r := range rows {
+		dict.types[r.Code] = r.SID
+	}
+
+	d.store(s.Name, gen, dict)
+
+	return dict.types, nil
+}
+
+// store caches dict unless the source was invalidated since generation gen
+// was read.
+func (d *Dictionaries) store(name string, gen uint64, dict *dictionary) {
+	d.mu.Lock()
+	if d.gens[name] == gen {
+		d.dicts[name] = dict
+	}
+	d.mu.Unlock()
+}
+
+// Invalidate forgets the dictionary of the named source, it is loaded again
+// on next use.
+func (d *Dictionaries) Invalidate(name string) {
+	d.mu.Lock()
+	delete(d.dicts, name)
+	d.gens[name]++
+	d.mu.Unlock()
+}
@@ -0,0 +1,117 @@
+package datasource
+
+import (
+	"context"
+	"github.com/jmoiron/sqlx"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testDictionary = `
+CREATE TABLE fty_dictionary_type (sid TEXT PRIMARY KEY, code TEXT NOT NULL, is_delete INTEGER NOT NULL, status INTEGER NOT NULL);
+INSERT INTO fty_dictionary_type VALUES ('s1', 'prod-weight', 0, 1), ('s2', 'prod-old', 1, 1), ('s3', 'prod-draft', 0, 2);
+`
+
+func testDictionarySource(t *testing.T) (*Source, func()) {
+	db, cleanup := testSQLite(t)
+	if _, err := db.Exec(testDictionary); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	return &Source{Name: "lite", DB: db, Dialect: sqliteDialect{}}, cleanup
+}
+
+func TestDictionariesTypes(t *testing.T) {
+	s, cleanup := testDictionarySource(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	d := NewDictionaries(0, 1)
+	types, err := d.Types(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"prod-weight": "s1"}; !reflect.DeepEqual(types, want) {
+		t.Errorf("got %v, want %v", types, want)
+	}
+
+	s.DB.MustExec("INSERT INTO fty_dictionary_type VALUES ('s4', 'prod-new', 0, 1)")
+	if types, _ := d.Types(ctx, s); len(types) != 1 {
+		t.Errorf("cached types reloaded before invalidation: %v", types)
+	}
+
+	d.Invalidate("lite")
+	if types, _ := d.Types(ctx, s); len(types) != 2 {
+		t.Errorf("types not reloaded after invalidation: %v", types)
+	}
+
+	drafts, err := NewDictionaries(0, 2).Types(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"prod-draft": "s3"}; !reflect.DeepEqual(drafts, want) {
+		t.Errorf("status 2: got %v, want %v", drafts, want)
+	}
+}
+
+func TestDictionariesDropLoadsOlderThanInvalidation(t *testing.T) {
+	d := NewDictionaries(0, 1)
+
+	d.mu.Lock()
+	gen := d.gens["lite"]
+	d.mu.Unlock()
+
+	// the load of gen ends after an invalidation
+	d.Invalidate("lite")
+	d.store("lite", gen, &dictionary{types: map[string]string{"stale": "s0"}})
+	if _, ok := d.dicts["lite"]; ok {
+		t.Error("a load older than the invalidation was cached")
+	}
+
+	d.store("lite", gen+1, &dictionary{types: map[string]string{"fresh": "s1"}})
+	if _, ok := d.dicts["lite"]; !ok {
+		t.Error("a load newer than the invalidation was not cached")
+	}
+}
+
+func TestDictionariesReload(t *testing.T) {
+	tests := []struct {
+		name string
+		ttl  time.Duration
+		age  time.Duration
+		// rebuilt replaces the pool of the source after the first load
+		rebuilt bool
+		reload  bool
+	}{
+		{"fresh", time.Minute, time.Second, false, false},
+		{"expired", time.Minute, 2 * time.Minute, false, true},
+		{"no ttl", 0, time.Hour, false, false},
+		{"pool rebuilt", time.Minute, time.Second, true, true},
+	}
+	for _, tt := range tests {
+		s, cleanup := testDictionarySource(t)
+		ctx := context.Background()
+
+		d := NewDictionaries(tt.ttl, 1)
+		if _, err := d.Types(ctx, s); err != nil {
+			cleanup()
+			t.Fatal(err)
+		}
+		d.dicts["lite"].loadedAt = time.Now().Add(-tt.age)
+
+		s.DB.MustExec("INSERT INTO fty_dictionary_type VALUES ('s4', 'prod-new', 0, 1)")
+		if tt.rebuilt {
+			s = &Source{Name: s.Name, DB: sqlx.NewDb(s.DB.DB, s.DB.DriverName()), Dialect: s.Dialect}
+		}
+
+		types, err := d.Types(ctx, s)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if reloaded := len(types) == 2; reloaded != tt.reload {
+			t.Errorf("%s: reloaded %v, want %v", tt.name, reloaded, tt.reload)
+		}
+		cleanup()
+	}
+}
@@ -0,0 +1,87 @@
+package datasource
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// maxIdentLength is the shortest identifier limit of the supported databases
+const maxIdentLength = 63
+
+// identPattern is what an identifier taken from a doc or a dictionary may be
+// made of, anything else is rejected rather than quoted
+var identPattern = regexp.MustCompile(`^[\p{L}_][\p{L}\p{N}_$-]*$`)
+
+// ValidIdent rejects the names that are not safe to use as identifiers.
+func ValidIdent(name string) error {
+	if name == "" {
+		return fmt.Errorf("identifier is empty")
+	}
+	if len(name) > maxIdentLength {
+		return fmt.Errorf("identifier %q is longer than %d bytes", name, maxIdentLength)
+	}
+	if !identPattern.MatchString(name) {
+		return fmt.Errorf("identifier %q contains unsafe characters", name)
+	}
+	return nil
+}
+
+// QuoteIdent validates name, folds it and quotes it for d. A qualified name is
+// quoted part by part.
+func QuoteIdent(d Dialect, name string) (string, error) {
+	parts := strings.Split(name, ".")
+	for i, p := range parts {
+		if err := ValidIdent(p); err != nil {
+			return "", err
+		}
+		parts[i] = d.QuoteIdent(d.FoldIdent(p))
+	}
+	return strings.Join(parts, "."), nil
+}
+
+// SanitizeAlias turns s into a plain alias of d: every character but letters,
+// digits and underscores becomes an underscore, a leading digit gets an
+// underscore in front and the alias is folded like an unquoted name.
+func SanitizeAlias(d Dialect, s string) (string, error) {
+	alias := strings.Map(func(r rune) rune {
+		if r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '_'
+	}, s)
+
+	if alias != "" && unicode.IsDigit([]rune(alias)[0]) {
+		alias = "_" + alias
+	}
+	alias = d.FoldIdent(alias)
+
+	if err := ValidIdent(alias); err != nil {
+		return "", fmt.Errorf("no alias for %q: %v", s, err)
+	}
+	return alias, nil
+}
+
+// quoteWith encloses s in open and close, doubling close inside of it
+func quoteWith(s string, open, close string) string {
+	return open + strings.Replace(s, close, close+close, -1) + close
+}
+
+// MySQL compares identifiers case insensitively, table names aside
+func (mysqlDialect) FoldIdent(name string) string { return name }
+
+func (mysqlDialect) QuoteIdent(name string) string { return quoteWith(name, "`", "`") }
+
+// PostgreSQL folds unquoted names to lower case
+func (postgresDialect) FoldIdent(name string) string { return strings.ToLower(name) }
+
+func (postgresDialect) QuoteIdent(name string) string { return quoteWith(name, `"`, `"`) }
+
+func (sqliteDialect) FoldIdent(name string) string { return name }
+
+func (sqliteDialect) QuoteIdent(name string) string { return quoteWith(name, `"`, `"`) }
+
+func (sqlserverDialect) FoldIdent(name string) string { return name }
+
+func (sqlserverDialect) QuoteIdent(name string) string { return quoteWith(name, "[", "]") }
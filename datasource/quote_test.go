@@ -0,0 +1,76 @@
+package datasource
+
+import "testing"
+
+func TestQuoteIdent(t *testing.T) {
+	tests := []struct {
+		dialect Dialect
+		name    string
+		want    string
+	}{
+		{mysqlDialect{}, "weight", "`weight`"},
+		{mysqlDialect{}, "fty_product.Weight", "`fty_product`.`Weight`"},
+		{postgresDialect{}, "fty_product.Weight", `"fty_product"."weight"`},
+		{sqliteDialect{}, "prod-weight", `"prod-weight"`},
+		{sqlserverDialect{}, "dbo.Orders", "[dbo].[Orders]"},
+		{mysqlDialect{}, "重量", "`重量`"},
+	}
+	for _, tt := range tests {
+		got, err := QuoteIdent(tt.dialect, tt.name)
+		if err != nil || got != tt.want {
+			t.Errorf("%s: QuoteIdent(%q) = %q, %v, want %q", tt.dialect.Driver(), tt.name, got, err, tt.want)
+		}
+	}
+
+	for _, name := range []string{"", "a`b", `a"b`, "a]b", "w; DROP TABLE orders --", "a..b", "1a"} {
+		for _, d := range dialects {
+			if got, err := QuoteIdent(d, name); err == nil {
+				t.Errorf("%s: QuoteIdent(%q) = %q, want an error", d.Driver(), name, got)
+			}
+		}
+	}
+}
+
+func TestSanitizeAlias(t *testing.T) {
+	tests := []struct {
+		dialect Dialect
+		s       string
+		want    string
+	}{
+		{mysqlDialect{}, "prod-weight", "prod_weight"},
+		{mysqlDialect{}, "Prod Weight", "Prod_Weight"},
+		{postgresDialect{}, "Prod Weight", "prod_weight"},
+		{sqliteDialect{}, "1st", "_1st"},
+		{sqlserverDialect{}, "a`b]c", "a_b_c"},
+		{mysqlDialect{}, "重量", "重量"},
+	}
+	for _, tt := range tests {
+		got, err := SanitizeAlias(tt.dialect, tt.s)
+		if err != nil || got != tt.want {
+			t.Errorf("%s: SanitizeAlias(%q) = %q, %v, want %q", tt.dialect.Driver(), tt.s, got, err, tt.want)
+		}
+	}
+
+	if got, err := SanitizeAlias(mysqlDialect{}, ""); err == nil {
+		t.Errorf("SanitizeAlias of an empty string = %q, want an error", got)
+	}
+}
+
+func TestSQLiteQuoting(t *testing.T) {
+	db, cleanup := testSQLite(t)
+	defer cleanup()
+
+	d := sqliteDialect{}
+	column, err := QuoteIdent(d, "prod-weight")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got string
+	if err := db.Get(&got, "SELECT "+column+" FROM (SELECT 'w' AS "+column+")"); err != nil {
+		t.Fatal(err)
+	}
+	if got != "w" {
+		t.Errorf("got %q, want %q", got, "w")
+	}
+}
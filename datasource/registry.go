@@ -0,0 +1,259 @@
+package datasource
+
+import (
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+	"github.com/user/sqlcomposer-svc/models"
+	"sync"
+	"time"
+)
+
+// Source is a pooled connection to the database described by a DatabaseConfig row.
+type Source struct {
+	Name string
+	DB   *sqlx.DB
+	// Dialect is the dialect of the driver of the source
+	Dialect Dialect
+	// Loc is the time zone of the DSN, datetimes read as text are in it
+	Loc *time.Location
+	// Killer is a small pool of its own for the statements killing a query,
+	// nil when the dialect has none. A kill must not wait for a connection
+	// of DB, the pool may be exhausted by the very queries to kill.
+	Killer *sqlx.DB
+
+	driver    string
+	dsn       string
+	updatedAt time.Time
+
+	// refs counts the requests using the source, a retired source is closed
+	// by its last release. Both are guarded by the registry lock.
+	refs    int
+	retired bool
+}
+
+// stale reports whether the source was opened from an older version of dbc.
+func (s *Source) stale(dbc *models.DatabaseConfig) bool {
+	return s.driver != dbc.Driver || s.dsn != dbc.DSN.String || !s.updatedAt.Equal(dbc.UpdatedAt.Time)
+}
+
+// killerConns bounds the kill pool of a source, kills are short and rare
+const killerConns = 2
+
+type Config struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// Registry keeps one connection pool per datasource name, rebuilding it whenever
+// the DatabaseConfig row it was opened from changes.
+type Registry struct {
+	cfg Config
+
+	mu      sync.Mutex
+	sources map[string]*Source
+	closed  bool
+	onEvict []func(name string)
+}
+
+func NewRegistry(cfg *Config) *Registry {
+	return &Registry{
+		cfg:     *cfg,
+		sources: make(map[string]*Source),
+	}
+}
+
+// Get returns the pooled source for dbc, connecting on first use and
+// reconnecting when the DSN or updated_at of the row differs from the pooled
+// one. The caller must Release the source once it is done with it.
+func (r *Registry) Get(dbc *models.DatabaseConfig) (*Source, error) {
+	name := dbc.Name.String
+
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil, fmt.Errorf("datasource registry is closed")
+	}
+	if s, ok := r.sources[name]; ok && !s.stale(dbc) {
+		s.refs++
+		r.mu.Unlock()
+		return s, nil
+	}
+	r.mu.Unlock()
+
+	if !dbc.DSN.Valid || dbc.DSN.String == "" {
+		return nil, fmt.Errorf("datasource %s has no dsn", name)
+	}
+
+	ns, err := r.open(dbc)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		r.closeSource(ns)
+		return nil, fmt.Errorf("datasource registry is closed")
+	}
+	cur, ok := r.sources[name]
+	if ok && !cur.stale(dbc) {
+		// another request connected first, keep its pool
+		cur.refs++
+		r.mu.Unlock()
+		r.closeSource(ns)
+		return cur, nil
+	}
+	ns.refs++
+	r.sources[name] = ns
+	var unused *Source
+	if ok {
+		unused = r.retire(cur)
+	}
+	r.mu.Unlock()
+
+	if ok {
+		log.WithField("datasource", name).Info("datasource changed, pool rebuilt")
+		r.evicted(name)
+	}
+	if unused != nil {
+		r.closeSource(unused)
+	}
+
+	return ns, nil
+}
+
+// Release ends a use of s started by Get. The last release of a retired
+// source closes it.
+func (r *Registry) Release(s *Source) {
+	r.mu.Lock()
+	s.refs--
+	unused := s.retired && s.refs == 0
+	r.mu.Unlock()
+
+	if unused {
+		r.closeSource(s)
+	}
+}
+
+// retire marks s as no longer handed out, it returns s when no request uses
+// it anymore so that the caller closes it. The registry lock must be held.
+func (r *Registry) retire(s *Source) *Source {
+	s.retired = true
+	if s.refs == 0 {
+		return s
+	}
+	return nil
+}
+
+func (r *Registry) open(dbc *models.DatabaseConfig) (*Source, error) {
+	dialect, err := DialectOf(dbc.Driver)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sqlx.Connect(dialect.Driver(), dbc.DSN.String)
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(r.cfg.MaxOpenConns)
+	db.SetMaxIdleConns(r.cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(r.cfg.ConnMaxLifetime)
+
+	loc := time.UTC
+	if dialect.Driver() == DriverMySQL {
+		if mc, err := mysql.ParseDSN(dbc.DSN.String); err == nil && mc.Loc != nil {
+			loc = mc.Loc
+		}
+	}
+
+	var killer *sqlx.DB
+	if dialect.ConnectionID() != "" {
+		if killer, err = sqlx.Open(dialect.Driver(), dbc.DSN.String); err != nil {
+			db.Close()
+			return nil, err
+		}
+		killer.SetMaxOpenConns(killerConns)
+		killer.SetMaxIdleConns(killerConns)
+		killer.SetConnMaxLifetime(r.cfg.ConnMaxLifetime)
+	}
+
+	return &Source{
+		Name:      dbc.Name.String,
+		DB:        db,
+		Dialect:   dialect,
+		Loc:       loc,
+		Killer:    killer,
+		driver:    dbc.Driver,
+		dsn:       dbc.DSN.String,
+		updatedAt: dbc.UpdatedAt.Time,
+	}, nil
+}
+
+// Evict forgets the pool of the named datasource. It is closed once the
+// requests using it release it.
+func (r *Registry) Evict(name string) {
+	r.mu.Lock()
+	var unused *Source
+	if s, ok := r.sources[name]; ok {
+		delete(r.sources, name)
+		unused = r.retire(s)
+	}
+	r.mu.Unlock()
+
+	if unused != nil {
+		r.closeSource(unused)
+	}
+	r.evicted(name)
+}
+
+// OnEvict calls f with the name of every datasource that is evicted or whose
+// pool is rebuilt, so that what was cached for it can be dropped.
+func (r *Registry) OnEvict(f func(name string)) {
+	r.mu.Lock()
+	r.onEvict = append(r.onEvict, f)
+	r.mu.Unlock()
+}
+
+func (r *Registry) evicted(name string) {
+	r.mu.Lock()
+	hooks := r.onEvict
+	r.mu.Unlock()
+
+	for _, f := range hooks {
+		f(name)
+	}
+}
+
+// Close retires every pool, Get fails afterwards. Pools in use are closed by
+// their last release.
+func (r *Registry) Close() {
+	r.mu.Lock()
+	var unused []*Source
+	for _, s := range r.sources {
+		if u := r.retire(s); u != nil {
+			unused = append(unused, u)
+		}
+	}
+	r.sources = make(map[string]*Source)
+	r.closed = true
+	r.mu.Unlock()
+
+	for _, s := range unused {
+		r.closeSource(s)
+	}
+}
+
+func (r *Registry) closeSource(s *Source) {
+	if err := s.DB.Close(); err != nil {
+		log.WithField("datasource", s.Name).Error(err)
+	}
+	if s.Killer != nil {
+		if err := s.Killer.Close(); err != nil {
+			log.WithField("datasource", s.Name).Error(err)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package datasource
+
+import (
+	"github.com/user/sqlcomposer-svc/models"
+	"github.com/volatiletech/null/v8"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testConfig describes a SQLite datasource in a new directory, removed by
+// cleanup
+func testConfig(t *testing.T, name string) (dbc *models.DatabaseConfig, cleanup func()) {
+	dir, err := ioutil.TempDir("", "datasource")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &models.DatabaseConfig{
+		Name:      null.StringFrom(name),
+		DSN:       null.StringFrom("file:" + filepath.Join(dir, name+".db")),
+		Driver:    DriverSQLite,
+		UpdatedAt: null.TimeFrom(time.Date(2020, 8, 1, 0, 0, 0, 0, time.UTC)),
+	}, func() { os.RemoveAll(dir) }
+}
+
+func testRegistry() *Registry {
+	return NewRegistry(&Config{MaxOpenConns: 2, MaxIdleConns: 1})
+}
+
+func TestRegistryReleaseClosesRetiredSource(t *testing.T) {
+	r := testRegistry()
+	defer r.Close()
+	dbc, cleanup := testConfig(t, "lite")
+	defer cleanup()
+
+	old, err := r.Get(dbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dbc.UpdatedAt = null.TimeFrom(dbc.UpdatedAt.Time.Add(time.Minute))
+	ns, err := r.Get(dbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ns == old {
+		t.Fatal("changed datasource kept its pool")
+	}
+
+	if err := old.DB.Ping(); err != nil {
+		t.Errorf("retired source closed while in use: %v", err)
+	}
+
+	r.Release(old)
+	if err := old.DB.Ping(); err == nil {
+		t.Error("retired source still open after its last release")
+	}
+
+	r.Release(ns)
+	if err := ns.DB.Ping(); err != nil {
+		t.Errorf("current source closed by a release: %v", err)
+	}
+}
+
+func TestRegistryEvictWaitsForRelease(t *testing.T) {
+	r := testRegistry()
+	defer r.Close()
+	dbc, cleanup := testConfig(t, "lite")
+	defer cleanup()
+
+	s, err := r.Get(dbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := r.Get(dbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != s {
+		t.Fatal("unchanged datasource got a new pool")
+	}
+
+	r.Evict("lite")
+	r.Release(again)
+	if err := s.DB.Ping(); err != nil {
+		t.Errorf("evicted source closed while in use: %v", err)
+	}
+
+	r.Release(s)
+	if err := s.DB.Ping(); err == nil {
+		t.Error("evicted source still open after its last release")
+	}
+}
+
+func TestRegistryStale(t *testing.T) {
+	r := testRegistry()
+	defer r.Close()
+	dbc, cleanup := testConfig(t, "lite")
+	defer cleanup()
+
+	changes := []struct {
+		name   string
+		change func(dbc *models.DatabaseConfig)
+	}{
+		{"updated_at", func(dbc *models.DatabaseConfig) {
+			dbc.UpdatedAt = null.TimeFrom(dbc.UpdatedAt.Time.Add(time.Second))
+		}},
+		{"dsn", func(dbc *models.DatabaseConfig) {
+			dbc.DSN = null.StringFrom(dbc.DSN.String + "?cache=shared")
+		}},
+	}
+
+	cur, err := r.Get(dbc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Release(cur)
+
+	for _, c := range changes {
+		c.change(dbc)
+
+		s, err := r.Get(dbc)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		r.Release(s)
+		if s == cur {
+			t.Errorf("%s: changed datasource kept its pool", c.name)
+		}
+
+		again, err := r.Get(dbc)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		r.Release(again)
+		if again != s {
+			t.Errorf("%s: unchanged datasource got a new pool", c.name)
+		}
+
+		cur = s
+	}
+
+	// a source of another driver can not be opened here, stale is enough
+	other := *dbc
+	other.Driver = DriverPostgres
+	if !cur.stale(&other) {
+		t.Error("driver change is not stale")
+	}
+}
@@ -10,6 +10,8 @@ import (
 	"github.com/jmoiron/sqlx"
 	"github.com/rubenv/sql-migrate"
 	log "github.com/sirupsen/logrus"
+	"github.com/user/sqlcomposer-svc/composer"
+	"github.com/user/sqlcomposer-svc/datasource"
 	"github.com/user/sqlcomposer-svc/restapi"
 	"github.com/user/sqlcomposer-svc/restapi/v1"
 	"net/http"
@@ -23,6 +25,16 @@ type ServerConfig struct {
 	Host string `long:"host" description:"the IP to listen on" default:"localhost" env:"HOST"`
 	Port int    `long:"port" description:"the port to listen on for insecure connections" default:"8080" env:"PORT"`
 	DB   string `long:"db" description:"the database connection dns string" env:"DB"`
+
+	PoolMaxOpen     int           `long:"pool-max-open" description:"max open connections per datasource, 0 means unlimited" default:"20" env:"POOL_MAX_OPEN"`
+	PoolMaxIdle     int           `long:"pool-max-idle" description:"max idle connections per datasource" default:"5" env:"POOL_MAX_IDLE"`
+	PoolMaxLifetime time.Duration `long:"pool-max-lifetime" description:"max lifetime of a pooled connection" default:"30m" env:"POOL_MAX_LIFETIME"`
+
+	QueryTimeout    time.Duration `long:"query-timeout" description:"query timeout of docs without options.timeout" default:"30s" env:"QUERY_TIMEOUT"`
+	QueryMaxTimeout time.Duration `long:"query-max-timeout" description:"upper bound of the query timeout of any doc" default:"5m" env:"QUERY_MAX_TIMEOUT"`
+
+	DictionaryTTL    time.Duration `long:"dictionary-ttl" description:"how long the dictionary types of a datasource are cached, 0 means until invalidated" default:"5m" env:"DICTIONARY_TTL"`
+	DictionaryStatus int           `long:"dictionary-status" description:"the status of the enabled dictionary types, the others are unknown attributes" default:"1" env:"DICTIONARY_STATUS"`
 }
 
 func main() {
@@ -61,12 +73,32 @@ func main() {
 	}
 	log.Info(fmt.Sprintf("Applied %d migrations!", n))
 
+	sources := datasource.NewRegistry(&datasource.Config{
+		MaxOpenConns:    cfg.PoolMaxOpen,
+		MaxIdleConns:    cfg.PoolMaxIdle,
+		ConnMaxLifetime: cfg.PoolMaxLifetime,
+	})
+
+	docs := composer.NewCache()
+	dictionaries := datasource.NewDictionaries(cfg.DictionaryTTL, cfg.DictionaryStatus)
+	tokens := composer.NewTokenCache()
+
 	v1.Setup(&v1.Config{
-		DB: db,
+		DB:           db,
+		DataSources:  sources,
+		Docs:         docs,
+		Dictionaries: dictionaries,
+		Tokens:       tokens,
 	})
 
 	restapi.Setup(&restapi.Config{
-		DB: db,
+		DB:              db,
+		DataSources:     sources,
+		Docs:            docs,
+		Dictionaries:    dictionaries,
+		Tokens:          tokens,
+		QueryTimeout:    cfg.QueryTimeout,
+		QueryMaxTimeout: cfg.QueryMaxTimeout,
 	})
 
 	defer v1.Destroy()
@@ -88,7 +120,7 @@ func main() {
 
 	// Wait for interrupt signal to gracefully shutdown the server with
 	// a timeout of 5 seconds.
-	quit := make(chan os.Signal)
+	quit := make(chan os.Signal, 1)
 	// kill (no param) default send syscall.SIGTERM
 	// kill -2 is syscall.SIGINT
 	// kill -9 is syscall.SIGKILL but can't be catch, so don't need add it
@@ -0,0 +1,6 @@
+-- +migrate Up
+ALTER TABLE `database_config`
+  ADD COLUMN `driver` varchar(20) NOT NULL DEFAULT 'mysql' AFTER `dsn`;
+-- +migrate Down
+ALTER TABLE `database_config`
+  DROP COLUMN `driver`;
@@ -0,0 +1,19 @@
+-- +migrate Up
+CREATE TABLE `token`
+(
+  `id`          int(11)     NOT NULL AUTO_INCREMENT,
+  `uuid`        varchar(50) NOT NULL,
+  `name`        varchar(60) NOT NULL,
+  `db_name`     varchar(60) NOT NULL,
+  `template`    text        NOT NULL,
+  `params`      text,
+  `description` varchar(500) DEFAULT NULL,
+  `created_at`  datetime     DEFAULT NULL,
+  `updated_at`  datetime     DEFAULT NULL,
+  `deleted_at`  datetime     DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `db_name_name` (`db_name`, `name`) USING BTREE
+) ENGINE = InnoDB
+  DEFAULT CHARSET = utf8mb4;
+-- +migrate Down
+DROP TABLE IF EXISTS `token`;
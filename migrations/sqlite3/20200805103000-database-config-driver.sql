@@ -0,0 +1,19 @@
+-- +migrate Up
+ALTER TABLE `database_config`
+  ADD COLUMN `driver` TEXT NOT NULL DEFAULT 'mysql';
+-- +migrate Down
+-- DROP COLUMN needs SQLite 3.35, the table is rebuilt without the column
+CREATE TABLE `database_config_down`
+(
+  `id`         INTEGER PRIMARY KEY,
+  `name`       TEXT NOT NULL UNIQUE,
+  `dsn`        TEXT NOT NULL UNIQUE,
+  `created_at` INTEGER,
+  `updated_at` INTEGER,
+  `deleted_at` INTEGER DEFAULT NULL
+);
+INSERT INTO `database_config_down` (`id`, `name`, `dsn`, `created_at`, `updated_at`, `deleted_at`)
+SELECT `id`, `name`, `dsn`, `created_at`, `updated_at`, `deleted_at`
+FROM `database_config`;
+DROP TABLE `database_config`;
+ALTER TABLE `database_config_down` RENAME TO `database_config`;
@@ -0,0 +1,17 @@
+-- +migrate Up
+CREATE TABLE `token`
+(
+  `id`          INTEGER PRIMARY KEY,
+  `uuid`        TEXT NOT NULL,
+  `name`        TEXT NOT NULL,
+  `db_name`     TEXT NOT NULL,
+  `template`    TEXT NOT NULL,
+  `params`      TEXT    DEFAULT NULL,
+  `description` TEXT    DEFAULT NULL,
+  `created_at`  INTEGER,
+  `updated_at`  INTEGER,
+  `deleted_at`  INTEGER DEFAULT NULL,
+  UNIQUE (`db_name`, `name`)
+);
+-- +migrate Down
+DROP TABLE `token`;
@@ -14,56 +14,67 @@ import "testing"
 func TestParent(t *testing.T) {
 	t.Run("DatabaseConfigs", testDatabaseConfigs)
 	t.Run("Docs", testDocs)
+	t.Run("Tokens", testTokens)
 }
 
 func TestDelete(t *testing.T) {
 	t.Run("DatabaseConfigs", testDatabaseConfigsDelete)
 	t.Run("Docs", testDocsDelete)
+	t.Run("Tokens", testTokensDelete)
 }
 
 func TestQueryDeleteAll(t *testing.T) {
 	t.Run("DatabaseConfigs", testDatabaseConfigsQueryDeleteAll)
 	t.Run("Docs", testDocsQueryDeleteAll)
+	t.Run("Tokens", testTokensQueryDeleteAll)
 }
 
 func TestSliceDeleteAll(t *testing.T) {
 	t.Run("DatabaseConfigs", testDatabaseConfigsSliceDeleteAll)
 	t.Run("Docs", testDocsSliceDeleteAll)
+	t.Run("Tokens", testTokensSliceDeleteAll)
 }
 
 func TestExists(t *testing.T) {
 	t.Run("DatabaseConfigs", testDatabaseConfigsExists)
 	t.Run("Docs", testDocsExists)
+	t.Run("Tokens", testTokensExists)
 }
 
 func TestFind(t *testing.T) {
 	t.Run("DatabaseConfigs", testDatabaseConfigsFind)
 	t.Run("Docs", testDocsFind)
+	t.Run("Tokens", testTokensFind)
 }
 
 func TestBind(t *testing.T) {
 	t.Run("DatabaseConfigs", testDatabaseConfigsBind)
 	t.Run("Docs", testDocsBind)
+	t.Run("Tokens", testTokensBind)
 }
 
 func TestOne(t *testing.T) {
 	t.Run("DatabaseConfigs", testDatabaseConfigsOne)
 	t.Run("Docs", testDocsOne)
+	t.Run("Tokens", testTokensOne)
 }
 
 func TestAll(t *testing.T) {
 	t.Run("DatabaseConfigs", testDatabaseConfigsAll)
 	t.Run("Docs", testDocsAll)
+	t.Run("Tokens", testTokensAll)
 }
 
 func TestCount(t *testing.T) {
 	t.Run("DatabaseConfigs", testDatabaseConfigsCount)
 	t.Run("Docs", testDocsCount)
+	t.Run("Tokens", testTokensCount)
 }
 
 func TestHooks(t *testing.T) {
 	t.Run("DatabaseConfigs", testDatabaseConfigsHooks)
 	t.Run("Docs", testDocsHooks)
+	t.Run("Tokens", testTokensHooks)
 }
 
 func TestInsert(t *testing.T) {
@@ -71,6 +82,8 @@ func TestInsert(t *testing.T) {
 	t.Run("DatabaseConfigs", testDatabaseConfigsInsertWhitelist)
 	t.Run("Docs", testDocsInsert)
 	t.Run("Docs", testDocsInsertWhitelist)
+	t.Run("Tokens", testTokensInsert)
+	t.Run("Tokens", testTokensInsertWhitelist)
 }
 
 // TestToOne tests cannot be run in parallel
@@ -116,24 +129,29 @@ func TestToManyRemove(t *testing.T) {}
 func TestReload(t *testing.T) {
 	t.Run("DatabaseConfigs", testDatabaseConfigsReload)
 	t.Run("Docs", testDocsReload)
+	t.Run("Tokens", testTokensReload)
 }
 
 func TestReloadAll(t *testing.T) {
 	t.Run("DatabaseConfigs", testDatabaseConfigsReloadAll)
 	t.Run("Docs", testDocsReloadAll)
+	t.Run("Tokens", testTokensReloadAll)
 }
 
 func TestSelect(t *testing.T) {
 	t.Run("DatabaseConfigs", testDatabaseConfigsSelect)
 	t.Run("Docs", testDocsSelect)
+	t.Run("Tokens", testTokensSelect)
 }
 
 func TestUpdate(t *testing.T) {
 	t.Run("DatabaseConfigs", testDatabaseConfigsUpdate)
 	t.Run("Docs", testDocsUpdate)
+	t.Run("Tokens", testTokensUpdate)
 }
 
 func TestSliceUpdateAll(t *testing.T) {
 	t.Run("DatabaseConfigs", testDatabaseConfigsSliceUpdateAll)
 	t.Run("Docs", testDocsSliceUpdateAll)
+	t.Run("Tokens", testTokensSliceUpdateAll)
 }
@@ -6,7 +6,9 @@ package models
 var TableNames = struct {
 	DatabaseConfig string
 	Doc            string
+	Token          string
 }{
 	DatabaseConfig: "database_config",
 	Doc:            "doc",
+	Token:          "token",
 }
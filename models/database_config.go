@@ -28,6 +28,7 @@ type DatabaseConfig struct {
 	UUID      string      `boil:"uuid" json:"uuid" toml:"uuid" yaml:"uuid"`
 	Name      null.String `boil:"name" json:"name,omitempty" toml:"name" yaml:"name,omitempty"`
 	DSN       null.String `boil:"dsn" json:"dsn,omitempty" toml:"dsn" yaml:"dsn,omitempty"`
+	Driver    string      `boil:"driver" json:"driver" toml:"driver" yaml:"driver"`
 	CreatedAt null.Time   `boil:"created_at" json:"created_at,omitempty" toml:"created_at" yaml:"created_at,omitempty"`
 	UpdatedAt null.Time   `boil:"updated_at" json:"updated_at,omitempty" toml:"updated_at" yaml:"updated_at,omitempty"`
 	DeletedAt null.Time   `boil:"deleted_at" json:"deleted_at,omitempty" toml:"deleted_at" yaml:"deleted_at,omitempty"`
@@ -41,6 +42,7 @@ var DatabaseConfigColumns = struct {
 	UUID      string
 	Name      string
 	DSN       string
+	Driver    string
 	CreatedAt string
 	UpdatedAt string
 	DeletedAt string
@@ -49,6 +51,7 @@ var DatabaseConfigColumns = struct {
 	UUID:      "uuid",
 	Name:      "name",
 	DSN:       "dsn",
+	Driver:    "driver",
 	CreatedAt: "created_at",
 	UpdatedAt: "updated_at",
 	DeletedAt: "deleted_at",
@@ -153,6 +156,7 @@ var DatabaseConfigWhere = struct {
 	UUID      whereHelperstring
 	Name      whereHelpernull_String
 	DSN       whereHelpernull_String
+	Driver    whereHelperstring
 	CreatedAt whereHelpernull_Time
 	UpdatedAt whereHelpernull_Time
 	DeletedAt whereHelpernull_Time
@@ -161,6 +165,7 @@ var DatabaseConfigWhere = struct {
 	UUID:      whereHelperstring{field: "`database_config`.`uuid`"},
 	Name:      whereHelpernull_String{field: "`database_config`.`name`"},
 	DSN:       whereHelpernull_String{field: "`database_config`.`dsn`"},
+	Driver:    whereHelperstring{field: "`database_config`.`driver`"},
 	CreatedAt: whereHelpernull_Time{field: "`database_config`.`created_at`"},
 	UpdatedAt: whereHelpernull_Time{field: "`database_config`.`updated_at`"},
 	DeletedAt: whereHelpernull_Time{field: "`database_config`.`deleted_at`"},
@@ -183,9 +188,9 @@ func (*databaseConfigR) NewStruct() *databaseConfigR {
 type databaseConfigL struct{}
 
 var (
-	databaseConfigAllColumns            = []string{"id", "uuid", "name", "dsn", "created_at", "updated_at", "deleted_at"}
+	databaseConfigAllColumns            = []string{"id", "uuid", "name", "dsn", "driver", "created_at", "updated_at", "deleted_at"}
 	databaseConfigColumnsWithoutDefault = []string{"uuid", "name", "dsn", "created_at", "updated_at", "deleted_at"}
-	databaseConfigColumnsWithDefault    = []string{"id"}
+	databaseConfigColumnsWithDefault    = []string{"id", "driver"}
 	databaseConfigPrimaryKeyColumns     = []string{"id"}
 )
 
@@ -568,7 +568,7 @@ func testDatabaseConfigsSelect(t *testing.T) {
 }
 
 var (
-	databaseConfigDBTypes = map[string]string{`ID`: `int`, `UUID`: `varchar`, `Name`: `varchar`, `DSN`: `varchar`, `CreatedAt`: `datetime`, `UpdatedAt`: `datetime`, `DeletedAt`: `datetime`}
+	databaseConfigDBTypes = map[string]string{`ID`: `int`, `UUID`: `varchar`, `Name`: `varchar`, `DSN`: `varchar`, `Driver`: `varchar`, `CreatedAt`: `datetime`, `UpdatedAt`: `datetime`, `DeletedAt`: `datetime`}
 	_                     = bytes.MinRead
 )
 
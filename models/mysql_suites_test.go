@@ -9,4 +9,5 @@ func TestUpsert(t *testing.T) {
 	t.Run("DatabaseConfigs", testDatabaseConfigsUpsert)
 
 	t.Run("Docs", testDocsUpsert)
+	t.Run("Tokens", testTokensUpsert)
 }
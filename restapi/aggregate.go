@@ -0,0 +1,187 @@
+package restapi
+
+import (
+	"fmt"
+	"github.com/user/sqlcomposer-svc/composer"
+	"github.com/user/sqlcomposer-svc/datasource"
+	"regexp"
+	"strings"
+)
+
+// Aggregate functions of a metric
+const (
+	aggCount         = "count"
+	aggSum           = "sum"
+	aggAvg           = "avg"
+	aggMin           = "min"
+	aggMax           = "max"
+	aggCountDistinct = "count_distinct"
+)
+
+var aliasPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// AggregateRequest groups the rows of the data subject by GroupBy and
+// computes Metrics for every group
+type AggregateRequest struct {
+	GroupBy []string           `json:"group_by"`
+	Metrics []*AggregateMetric `json:"metrics"`
+}
+
+// AggregateMetric is Fn over Field named As. Field may be left out for count,
+// As defaults to fn_field.
+type AggregateMetric struct {
+	Fn    string `json:"fn"`
+	Field string `json:"field"`
+	As    string `json:"as"`
+}
+
+// aggregateError reports why a part of the aggregation was rejected
+type aggregateError struct {
+	Part  string `json:"part"`
+	Index int    `json:"index"`
+	Err   string `json:"err"`
+}
+
+// aggregation is a validated AggregateRequest
+type aggregation struct {
+	groupBy []string
+	selects []string
+	sorts   []composer.SortSpec
+}
+
+// newAggregation validates the aggregation and its sorts, which may only use
+// the group by columns and the metric names.
+func newAggregation(doc *composer.Doc, req *AggregateRequest, sorts []*SortItem) (*aggregation, []*aggregateError) {
+	var errs []*aggregateError
+	agg := &aggregation{}
+
+	if _, ok := doc.Composition.Subject[composer.AggregateSubject]; !ok {
+		return nil, []*aggregateError{{Err: fmt.Sprintf("doc has no %s subject to aggregate", composer.AggregateSubject)}}
+	}
+
+	names := make(map[string]bool)
+	for i, g := range req.GroupBy {
+		if !doc.IsAggregatable(g) {
+			errs = append(errs, &aggregateError{Part: "group_by", Index: i, Err: fmt.Sprintf("%s is not aggregatable", g)})
+			continue
+		}
+		if names[g] {
+			errs = append(errs, &aggregateError{Part: "group_by", Index: i, Err: fmt.Sprintf("%s is grouped twice", g)})
+			continue
+		}
+		names[g] = true
+		agg.groupBy = append(agg.groupBy, g)
+		agg.selects = append(agg.selects, g)
+	}
+
+	for i, m := range req.Metrics {
+		expr, err := metricExpr(doc, m)
+		if err != nil {
+			errs = append(errs, &aggregateError{Part: "metrics", Index: i, Err: err.Error()})
+			continue
+		}
+
+		as := m.As
+		if as == "" {
+			as = strings.Trim(m.Fn+"_"+m.Field, "_")
+		}
+		if !aliasPattern.MatchString(as) {
+			errs = append(errs, &aggregateError{Part: "metrics", Index: i, Err: fmt.Sprintf("invalid metric name %q", as)})
+			continue
+		}
+		if names[as] {
+			errs = append(errs, &aggregateError{Part: "metrics", Index: i, Err: fmt.Sprintf("name %s is used twice", as)})
+			continue
+		}
+		names[as] = true
+		agg.selects = append(agg.selects, fmt.Sprintf("%s AS %s", expr, as))
+	}
+
+	if len(agg.selects) == 0 && len(errs) == 0 {
+		errs = append(errs, &aggregateError{Err: "aggregate needs group_by or metrics"})
+	}
+
+	for i, si := range sorts {
+		if si == nil || si.invalid != "" {
+			errs = append(errs, &aggregateError{Part: "sorts", Index: i, Err: "invalid sort"})
+			continue
+		}
+		spec, err := si.Normalize()
+		if err == nil && !names[spec.Field] {
+			err = fmt.Errorf("%s is neither grouped nor a metric", spec.Field)
+		}
+		if err != nil {
+			errs = append(errs, &aggregateError{Part: "sorts", Index: i, Err: err.Error()})
+			continue
+		}
+		agg.sorts = append(agg.sorts, spec)
+	}
+
+	return agg, errs
+}
+
+func metricExpr(doc *composer.Doc, m *AggregateMetric) (string, error) {
+	if m == nil {
+		return "", fmt.Errorf("metric is null")
+	}
+
+	if m.Field == "" {
+		if m.Fn == aggCount {
+			return "COUNT(*)", nil
+		}
+		return "", fmt.Errorf("%s needs a field", m.Fn)
+	}
+
+	if !doc.IsAggregatable(m.Field) {
+		return "", fmt.Errorf("%s is not aggregatable", m.Field)
+	}
+
+	switch m.Fn {
+	case aggCount, aggSum, aggAvg, aggMin, aggMax:
+		return fmt.Sprintf("%s(%s)", strings.ToUpper(m.Fn), m.Field), nil
+	case aggCountDistinct:
+		return fmt.Sprintf("COUNT(DISTINCT %s)", m.Field), nil
+	}
+
+	return "", fmt.Errorf("unknown aggregate function %q", m.Fn)
+}
+
+// queries wraps the statement of the data subject as a derived table. The
+// total counts the groups, there is none without group by.
+func (agg *aggregation) queries(dialect datasource.Dialect, inner string, args []interface{}, offset, size int64) (data *subjectQuery, total *subjectQuery) {
+	from := fmt.Sprintf("FROM (%s) AS agg", inner)
+
+	var groupBy string
+	if len(agg.groupBy) > 0 {
+		groupBy = " GROUP BY " + strings.Join(agg.groupBy, ", ")
+	}
+
+	var orderBy []string
+	for _, s := range agg.sorts {
+		switch s.Nulls {
+		case composer.NullsFirst:
+			orderBy = append(orderBy, composer.NullsTerm(s.Field)+" DESC")
+		case composer.NullsLast:
+			orderBy = append(orderBy, composer.NullsTerm(s.Field)+" ASC")
+		}
+		orderBy = append(orderBy, s.Field+" "+s.Dir)
+	}
+
+	q := fmt.Sprintf("SELECT %s %s%s", strings.Join(agg.selects, ", "), from, groupBy)
+	if len(orderBy) > 0 {
+		q += " ORDER BY " + strings.Join(orderBy, ", ")
+	}
+	q += " " + dialect.Limit(offset, size, len(orderBy) > 0)
+
+	data = &subjectQuery{Key: composer.AggregateSubject, SQL: q, Args: args}
+
+	if groupBy != "" {
+		total = &subjectQuery{
+			Key:  "total",
+			SQL:  fmt.Sprintf("SELECT COUNT(*) FROM (SELECT 1 AS one %s%s) AS agg_groups", from, groupBy),
+			Args: args,
+		}
+	}
+
+	return data, total
+}
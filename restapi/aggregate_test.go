@@ -0,0 +1,181 @@
+package restapi
+
+import (
+	"encoding/json"
+	"github.com/user/sqlcomposer-svc/composer"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const aggregateDoc = `
+info:
+  name: orders
+  version: 1.0.0
+composition:
+  subject:
+    data: "SELECT id, status, amount, note FROM orders %where ORDER BY id %limit"
+aggregatable: [status, amount, note]
+`
+
+func TestNewAggregation(t *testing.T) {
+	doc := testDoc(t, aggregateDoc)
+
+	sort := func(field, dir string) *SortItem {
+		return &SortItem{SortSpec: composer.SortSpec{Field: field, Dir: dir}}
+	}
+
+	tests := []struct {
+		name  string
+		req   AggregateRequest
+		sorts []*SortItem
+		want  []string
+		errs  []string
+	}{
+		{
+			name: "group with metrics",
+			req:  AggregateRequest{GroupBy: []string{"status"}, Metrics: []*AggregateMetric{{Fn: "count"}, {Fn: "sum", Field: "amount", As: "total_amount"}, {Fn: "count_distinct", Field: "note"}}},
+			want: []string{"status", "COUNT(*) AS count", "SUM(amount) AS total_amount", "COUNT(DISTINCT note) AS count_distinct_note"},
+		},
+		{
+			name:  "sort on a metric",
+			req:   AggregateRequest{GroupBy: []string{"status"}, Metrics: []*AggregateMetric{{Fn: "max", Field: "amount"}}},
+			sorts: []*SortItem{sort("max_amount", "desc")},
+			want:  []string{"status", "MAX(amount) AS max_amount"},
+		},
+		{
+			name: "nothing to compute",
+			req:  AggregateRequest{},
+			errs: []string{""},
+		},
+		{
+			name: "not aggregatable",
+			req:  AggregateRequest{GroupBy: []string{"id"}, Metrics: []*AggregateMetric{{Fn: "sum", Field: "id"}}},
+			errs: []string{"group_by", "metrics"},
+		},
+		{
+			name: "grouped twice",
+			req:  AggregateRequest{GroupBy: []string{"status", "status"}},
+			errs: []string{"group_by"},
+		},
+		{
+			name: "bad metrics",
+			req:  AggregateRequest{Metrics: []*AggregateMetric{nil, {Fn: "sum"}, {Fn: "median", Field: "amount"}, {Fn: "avg", Field: "amount", As: "a b"}, {Fn: "min", Field: "amount", As: "m"}, {Fn: "max", Field: "amount", As: "m"}}},
+			errs: []string{"metrics", "metrics", "metrics", "metrics", "metrics"},
+		},
+		{
+			name:  "sort on a column that is not grouped",
+			req:   AggregateRequest{GroupBy: []string{"status"}},
+			sorts: []*SortItem{sort("amount", "asc"), nil},
+			errs:  []string{"sorts", "sorts"},
+		},
+	}
+	for _, tt := range tests {
+		agg, errs := newAggregation(doc, &tt.req, tt.sorts)
+
+		if len(errs) != len(tt.errs) {
+			t.Errorf("%s: got errors %+v, want %v", tt.name, errs, tt.errs)
+			continue
+		}
+		for i, e := range errs {
+			if e.Part != tt.errs[i] {
+				t.Errorf("%s: error %d is in %q, want %q: %s", tt.name, i, e.Part, tt.errs[i], e.Err)
+			}
+		}
+		if tt.errs != nil {
+			continue
+		}
+
+		if got := strings.Join(agg.selects, ", "); got != strings.Join(tt.want, ", ") {
+			t.Errorf("%s: selects %s, want %s", tt.name, got, strings.Join(tt.want, ", "))
+		}
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	s := newTestService(t)
+	defer s.Close()
+	s.addDoc("/aggregate", aggregateDoc)
+
+	tests := []struct {
+		name  string
+		body  map[string]interface{}
+		want  string
+		total int
+	}{
+		{
+			"group by",
+			map[string]interface{}{
+				"aggregate": map[string]interface{}{"group_by": []string{"status"}, "metrics": []map[string]string{{"fn": "count"}, {"fn": "sum", "field": "amount"}}},
+				"sorts":     []map[string]string{{"field": "status", "dir": "desc"}},
+			},
+			`[{"status":"B","count":2,"sum_amount":200.25},{"status":"A","count":1,"sum_amount":10.5}]`,
+			2,
+		},
+		{
+			"filtered",
+			map[string]interface{}{
+				"aggregate": map[string]interface{}{"group_by": []string{"status"}, "metrics": []map[string]string{{"fn": "count"}}},
+				"filters":   []map[string]interface{}{{"attr": "amount", "op": ">", "val": 50}},
+			},
+			`[{"status":"B","count":2}]`,
+			1,
+		},
+		{
+			// the %limit of the data subject does not cut the rows aggregated
+			"paged groups",
+			map[string]interface{}{
+				"page_index": 1,
+				"page_limit": 1,
+				"aggregate":  map[string]interface{}{"group_by": []string{"status"}, "metrics": []map[string]string{{"fn": "count"}}},
+				"sorts":      []map[string]string{{"field": "count", "dir": "desc"}},
+			},
+			`[{"status":"B","count":2}]`,
+			2,
+		},
+		{
+			"metrics without group by",
+			map[string]interface{}{
+				"page_index": 1,
+				"page_limit": 1,
+				"aggregate":  map[string]interface{}{"metrics": []map[string]string{{"fn": "count"}, {"fn": "min", "field": "amount", "as": "least"}}},
+			},
+			`[{"count":3,"least":10.5}]`,
+			0,
+		},
+	}
+	for _, tt := range tests {
+		var res struct {
+			Data  json.RawMessage `json:"data"`
+			Total int             `json:"total"`
+		}
+		if code := s.post("/sql-composer/aggregate", tt.body, &res); code != http.StatusOK {
+			t.Fatalf("%s: got %d", tt.name, code)
+		}
+		if string(res.Data) != tt.want || res.Total != tt.total {
+			t.Errorf("%s: got %s total %d, want %s total %d", tt.name, res.Data, res.Total, tt.want, tt.total)
+		}
+	}
+
+	var res struct {
+		SQL map[string]string `json:"sql"`
+	}
+	body := map[string]interface{}{"aggregate": map[string]interface{}{"metrics": []map[string]string{{"fn": "count"}}}}
+	if code := s.post("/sql-composer/aggregate?debug=1", body, &res); code != http.StatusOK {
+		t.Fatalf("got %d", code)
+	}
+	if sql := res.SQL["data"]; strings.Count(sql, "LIMIT") != 1 {
+		t.Errorf("the inner statement is limited: %s", sql)
+	}
+
+	invalid := []map[string]interface{}{
+		{"aggregate": map[string]interface{}{"group_by": []string{"id"}}},
+		{"aggregate": map[string]interface{}{"group_by": []string{"status"}}, "fields": []string{"status"}},
+		{"aggregate": map[string]interface{}{"group_by": []string{"status"}}, "page_mode": "cursor"},
+	}
+	for _, body := range invalid {
+		if code := s.post("/sql-composer/aggregate", body, nil); code != http.StatusBadRequest {
+			t.Errorf("%v: got %d, want 400", body, code)
+		}
+	}
+}
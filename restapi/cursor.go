@@ -0,0 +1,319 @@
+package restapi
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/user/sqlcomposer-svc/composer"
+	"github.com/wangxb07/sqlcomposer"
+	"strings"
+	"time"
+)
+
+const (
+	pageModeOffset = "offset"
+	pageModeCursor = "cursor"
+
+	defaultCursorLimit = 10
+)
+
+// cursorPayload is what an opaque cursor decodes to. Sorts is the signature
+// of the ordering the values were read under.
+type cursorPayload struct {
+	Sorts  string        `json:"s"`
+	Values []interface{} `json:"v"`
+}
+
+// keyset describes a keyset (seek) pagination over the requested sorts plus
+// the unique key of the document as tie breaker. Null values are ordered
+// explicitly with a NullsTerm, so that the seek predicate knows on which side
+// of the other values they are, only for the sorts asking for a nulls
+// placement and the fields listed in options.cursor.nullable. The other keys
+// keep a plain ordering an index can serve.
+type keyset struct {
+	sorts sqlcomposer.OrderBy
+	keys  []keysetKey
+	size  int64
+}
+
+// keysetKey is one sort of a keyset
+type keysetKey struct {
+	name   string
+	dir    sqlcomposer.Direction
+	expr   string
+	column string
+	// nulls is where null values are ordered, empty for a key that is never
+	// null
+	nulls string
+}
+
+func newKeyset(doc *composer.Doc, specs []composer.SortSpec, size int64) (*keyset, error) {
+	if doc.Options.Cursor == nil || doc.Options.Cursor.Key == "" {
+		return nil, fmt.Errorf("doc does not support cursor pagination")
+	}
+
+	if size <= 0 {
+		size = defaultCursorLimit
+	}
+
+	ks := &keyset{size: size}
+	key := doc.Options.Cursor.Key
+	hasKey := false
+
+	nullable := make(map[string]bool, len(doc.Options.Cursor.Nullable))
+	for _, f := range doc.Options.Cursor.Nullable {
+		nullable[f] = true
+	}
+
+	for _, s := range specs {
+		if s.Field == key {
+			hasKey = true
+		}
+
+		nulls := s.Nulls
+		if nulls == "" && nullable[s.Field] {
+			// the default of most databases, null is the smallest value
+			nulls = composer.NullsFirst
+			if s.Dir == sqlcomposer.DESC {
+				nulls = composer.NullsLast
+			}
+		}
+		ks.add(doc, s.Field, sqlcomposer.Direction(s.Dir), nulls)
+	}
+
+	if !hasKey {
+		ks.add(doc, key, sqlcomposer.ASC, "")
+	}
+
+	return ks, nil
+}
+
+func (ks *keyset) add(doc *composer.Doc, name string, dir sqlcomposer.Direction, nulls string) {
+	k := keysetKey{
+		name:   name,
+		dir:    dir,
+		expr:   doc.FieldExpr(name),
+		column: columnName(name),
+		nulls:  nulls,
+	}
+	ks.keys = append(ks.keys, k)
+
+	switch nulls {
+	case composer.NullsFirst:
+		ks.sorts = append(ks.sorts, sqlcomposer.Sort{Name: composer.NullsTerm(k.expr), Direction: sqlcomposer.DESC})
+	case composer.NullsLast:
+		ks.sorts = append(ks.sorts, sqlcomposer.Sort{Name: composer.NullsTerm(k.expr), Direction: sqlcomposer.ASC})
+	}
+	ks.sorts = append(ks.sorts, sqlcomposer.Sort{Name: name, Direction: dir})
+}
+
+// plain tells whether no key orders nulls and all keys go the same direction,
+// the seek is then a single row value comparison.
+func (ks *keyset) plain() bool {
+	for _, k := range ks.keys {
+		if k.nulls != "" || k.dir != ks.keys[0].dir {
+			return false
+		}
+	}
+	return true
+}
+
+// columnName is the result column of a sort, the part after the table qualifier
+func columnName(name string) string {
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
+
+func (ks *keyset) signature() string {
+	var parts []string
+	for _, k := range ks.keys {
+		parts = append(parts, fmt.Sprintf("%s:%s:%s", k.name, k.dir, k.nulls))
+	}
+	return strings.Join(parts, ",")
+}
+
+// decode checks the cursor was produced for the same sorts and returns its values
+func (ks *keyset) decode(cursor string) ([]interface{}, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	var p cursorPayload
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&p); err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	if p.Sorts != ks.signature() || len(p.Values) != len(ks.keys) {
+		return nil, fmt.Errorf("cursor does not match the requested sorts")
+	}
+
+	for i, v := range p.Values {
+		switch v := v.(type) {
+		case nil:
+			if ks.keys[i].nulls == "" {
+				return nil, fmt.Errorf("invalid cursor")
+			}
+		case json.Number:
+			if n, err := v.Int64(); err == nil {
+				p.Values[i] = n
+			} else if f, err := v.Float64(); err == nil {
+				p.Values[i] = f
+			} else {
+				return nil, fmt.Errorf("invalid cursor")
+			}
+		}
+	}
+
+	return p.Values, nil
+}
+
+// encode builds the cursor pointing after the row of the raw scanned values
+func (ks *keyset) encode(columns []string, vals []interface{}) (string, error) {
+	p := cursorPayload{Sorts: ks.signature()}
+
+	for _, k := range ks.keys {
+		idx := -1
+		for i, name := range columns {
+			if name == k.column {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			return "", fmt.Errorf("sort field %s is not selected, it can not be used for cursor pagination", k.column)
+		}
+
+		switch v := vals[idx].(type) {
+		case nil:
+			if k.nulls == "" {
+				return "", fmt.Errorf("cursor key %s is null, list it in options.cursor.nullable or request its nulls placement", k.column)
+			}
+			p.Values = append(p.Values, nil)
+		case []byte:
+			p.Values = append(p.Values, string(v))
+		case time.Time:
+			p.Values = append(p.Values, v.Format("2006-01-02 15:04:05.999999"))
+		default:
+			p.Values = append(p.Values, v)
+		}
+	}
+
+	b, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// predicate builds the seek condition selecting the rows after vals. With
+// rowValues and a plain keyset it is (k1, k2) > (v1, v2), otherwise
+// (k1 > v1) OR (k1 = v1 AND k2 > v2) OR ... with < for descending sorts,
+// bounded by k1 >= v1 when the first key is plain so an index on it is used.
+// A null value is equal to the other nulls only, and before or after every
+// other value as its key orders nulls. Placeholder names avoid the ones in
+// taken so combining does not rename them.
+func (ks *keyset) predicate(vals []interface{}, taken map[string]interface{}, rowValues bool) *sqlcomposer.ConditionStmt {
+	stmt := &sqlcomposer.ConditionStmt{
+		Arg:         map[string]interface{}{},
+		ClauseSlice: map[string]string{},
+	}
+
+	n := 0
+	param := func(v interface{}) string {
+		for {
+			n++
+			name := fmt.Sprintf("cursor_seek_%d", n)
+			if _, ok := taken[name]; !ok {
+				stmt.Arg[name] = v
+				return ":" + name
+			}
+		}
+	}
+
+	if rowValues && ks.plain() {
+		exprs := make([]string, len(ks.keys))
+		params := make([]string, len(ks.keys))
+		for i, k := range ks.keys {
+			exprs[i] = k.expr
+			params[i] = param(vals[i])
+		}
+
+		op := ">"
+		if ks.keys[0].dir == sqlcomposer.DESC {
+			op = "<"
+		}
+		stmt.Clause = fmt.Sprintf("(%s) %s (%s)", strings.Join(exprs, ", "), op, strings.Join(params, ", "))
+		stmt.ClauseSlice["cursor"] = stmt.Clause
+
+		return stmt
+	}
+
+	var ors []string
+	for i, k := range ks.keys {
+		after, ok := k.after(vals[i], param)
+		if !ok {
+			// nothing is after a null ordered last
+			continue
+		}
+
+		var ands []string
+		for j := 0; j < i; j++ {
+			ands = append(ands, ks.keys[j].equal(vals[j], param))
+		}
+		ands = append(ands, after)
+
+		ors = append(ors, fmt.Sprintf("(%s)", strings.Join(ands, " AND ")))
+	}
+
+	switch first := ks.keys[0]; {
+	case len(ors) == 0:
+		stmt.Clause = "1 = 0"
+	case len(ors) > 1 && first.nulls == "":
+		op := ">="
+		if first.dir == sqlcomposer.DESC {
+			op = "<="
+		}
+		stmt.Clause = fmt.Sprintf("%s %s %s AND (%s)", first.expr, op, param(vals[0]), strings.Join(ors, " OR "))
+	default:
+		stmt.Clause = strings.Join(ors, " OR ")
+	}
+	stmt.ClauseSlice["cursor"] = stmt.Clause
+
+	return stmt
+}
+
+// equal is the condition of k being v
+func (k *keysetKey) equal(v interface{}, param func(interface{}) string) string {
+	if v == nil {
+		return fmt.Sprintf("%s IS NULL", k.expr)
+	}
+	return fmt.Sprintf("%s = %s", k.expr, param(v))
+}
+
+// after is the condition of k being ordered after v, false when no value is.
+func (k *keysetKey) after(v interface{}, param func(interface{}) string) (string, bool) {
+	if v == nil {
+		if k.nulls == composer.NullsLast {
+			return "", false
+		}
+		return fmt.Sprintf("%s IS NOT NULL", k.expr), true
+	}
+
+	op := ">"
+	if k.dir == sqlcomposer.DESC {
+		op = "<"
+	}
+	cond := fmt.Sprintf("%s %s %s", k.expr, op, param(v))
+
+	if k.nulls == composer.NullsLast {
+		return fmt.Sprintf("(%s OR %s IS NULL)", cond, k.expr), true
+	}
+	return cond, true
+}
@@ -0,0 +1,226 @@
+package restapi
+
+import (
+	"encoding/base64"
+	"github.com/user/sqlcomposer-svc/composer"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const cursorDoc = `
+info:
+  name: orders
+  version: 1.0.0
+composition:
+  subject:
+    total: "SELECT COUNT(*) FROM orders %where"
+    data: "SELECT id, status, note FROM orders %where %order_by %limit"
+sortable: [id, status, note]
+options:
+  cursor:
+    key: id
+    nullable: [note]
+`
+
+func TestKeysetCursor(t *testing.T) {
+	doc := testDoc(t, cursorDoc)
+	cols := []string{"id", "status", "note"}
+
+	ks, err := newKeyset(doc, []composer.SortSpec{{Field: "note", Dir: "ASC"}}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := [][]interface{}{
+		{int64(2), []byte("B"), nil},
+		{int64(3), "B", []byte("y")},
+	}
+	for _, vals := range tests {
+		cursor, err := ks.encode(cols, vals)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ks.decode(cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 2 || got[1] != vals[0] {
+			t.Errorf("%v decoded to %#v", vals, got)
+		}
+		if note, ok := vals[2].([]byte); ok && got[0] != string(note) || !ok && got[0] != nil {
+			t.Errorf("%v decoded to %#v", vals, got)
+		}
+	}
+
+	// a null plain key can not be paged over
+	plain, _ := newKeyset(doc, []composer.SortSpec{{Field: "status", Dir: "ASC"}}, 2)
+	if _, err := plain.encode(cols, []interface{}{int64(2), nil, nil}); err == nil {
+		t.Error("encoded a null plain key")
+	}
+}
+
+func TestKeysetDecodeTampered(t *testing.T) {
+	doc := testDoc(t, cursorDoc)
+	ks, _ := newKeyset(doc, []composer.SortSpec{{Field: "status", Dir: "ASC"}}, 2)
+	other, _ := newKeyset(doc, []composer.SortSpec{{Field: "status", Dir: "DESC"}}, 2)
+
+	cursor, err := ks.encode([]string{"id", "status"}, []interface{}{int64(1), []byte("A")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCursor, _ := other.encode([]string{"id", "status"}, []interface{}{int64(1), []byte("A")})
+
+	encode := func(s string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(s))
+	}
+
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"not base64", "!!" + cursor},
+		{"not json", encode("{")},
+		{"other sorts", otherCursor},
+		{"missing value", encode(`{"s":"status:ASC:,id:ASC:","v":["A"]}`)},
+		{"null plain key", encode(`{"s":"status:ASC:,id:ASC:","v":["A",null]}`)},
+		{"not a number", encode(`{"s":"status:ASC:,id:ASC:","v":["A",1e999]}`)},
+	}
+	for _, tt := range tests {
+		if _, err := ks.decode(tt.cursor); err == nil {
+			t.Errorf("%s: decoded", tt.name)
+		}
+	}
+
+	if _, err := ks.decode(encode(`{"s":"status:ASC:,id:ASC:","v":["A",1]}`)); err != nil {
+		t.Errorf("untampered cursor: %v", err)
+	}
+}
+
+func TestKeysetPredicate(t *testing.T) {
+	doc := testDoc(t, cursorDoc)
+
+	tests := []struct {
+		specs     []composer.SortSpec
+		vals      []interface{}
+		rowValues bool
+		want      string
+	}{
+		{
+			[]composer.SortSpec{{Field: "status", Dir: "ASC"}}, []interface{}{"A", 1}, true,
+			"(status, id) > (:cursor_seek_1, :cursor_seek_2)",
+		},
+		{
+			[]composer.SortSpec{{Field: "status", Dir: "DESC"}, {Field: "id", Dir: "DESC"}}, []interface{}{"A", 1}, true,
+			"(status, id) < (:cursor_seek_1, :cursor_seek_2)",
+		},
+		{
+			[]composer.SortSpec{{Field: "status", Dir: "ASC"}}, []interface{}{"A", 1}, false,
+			"status >= :cursor_seek_4 AND ((status > :cursor_seek_1) OR (status = :cursor_seek_3 AND id > :cursor_seek_2))",
+		},
+		{
+			[]composer.SortSpec{{Field: "status", Dir: "DESC"}}, []interface{}{"A", 1}, true,
+			"status <= :cursor_seek_4 AND ((status < :cursor_seek_1) OR (status = :cursor_seek_3 AND id > :cursor_seek_2))",
+		},
+		{
+			[]composer.SortSpec{{Field: "note", Dir: "ASC"}}, []interface{}{"x", 1}, true,
+			"(note > :cursor_seek_1) OR (note = :cursor_seek_3 AND id > :cursor_seek_2)",
+		},
+		{
+			[]composer.SortSpec{{Field: "note", Dir: "DESC"}}, []interface{}{"x", 1}, true,
+			"((note < :cursor_seek_1 OR note IS NULL)) OR (note = :cursor_seek_3 AND id > :cursor_seek_2)",
+		},
+		{
+			[]composer.SortSpec{{Field: "note", Dir: "DESC"}}, []interface{}{nil, 1}, true,
+			"(note IS NULL AND id > :cursor_seek_1)",
+		},
+		{
+			[]composer.SortSpec{{Field: "status", Dir: "ASC", Nulls: composer.NullsFirst}}, []interface{}{nil, 1}, true,
+			"(status IS NOT NULL) OR (status IS NULL AND id > :cursor_seek_1)",
+		},
+	}
+	for _, tt := range tests {
+		ks, err := newKeyset(doc, tt.specs, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stmt := ks.predicate(tt.vals, map[string]interface{}{}, tt.rowValues)
+		if stmt.Clause != tt.want {
+			t.Errorf("%+v: got %s, want %s", tt.specs, stmt.Clause, tt.want)
+		}
+		for name := range stmt.Arg {
+			if !strings.Contains(stmt.Clause, ":"+name) {
+				t.Errorf("%+v: arg %s is not in %s", tt.specs, name, stmt.Clause)
+			}
+		}
+	}
+
+	// placeholders of the request are not reused
+	ks, _ := newKeyset(doc, nil, 2)
+	stmt := ks.predicate([]interface{}{1}, map[string]interface{}{"cursor_seek_1": 0}, true)
+	if stmt.Clause != "(id) > (:cursor_seek_2)" {
+		t.Errorf("got %s", stmt.Clause)
+	}
+}
+
+func TestCursorPages(t *testing.T) {
+	s := newTestService(t)
+	defer s.Close()
+	s.addDoc("/cursor", cursorDoc)
+
+	tests := []struct {
+		sorts []map[string]string
+		want  []int
+	}{
+		{nil, []int{1, 2, 3}},
+		{[]map[string]string{{"field": "id", "dir": "DESC"}}, []int{3, 2, 1}},
+		{[]map[string]string{{"field": "status", "dir": "DESC"}}, []int{2, 3, 1}},
+		{[]map[string]string{{"field": "note", "dir": "ASC"}}, []int{2, 1, 3}},
+		{[]map[string]string{{"field": "note", "dir": "DESC"}}, []int{3, 1, 2}},
+		{[]map[string]string{{"field": "status", "dir": "ASC", "nulls": "last"}}, []int{1, 2, 3}},
+	}
+	for _, tt := range tests {
+		var got []int
+		cursor := ""
+		for page := 0; page < 5; page++ {
+			var res struct {
+				Data []struct {
+					ID int `json:"id"`
+				} `json:"data"`
+				Total      int    `json:"total"`
+				NextCursor string `json:"next_cursor"`
+			}
+			body := map[string]interface{}{"page_mode": "cursor", "page_limit": 2, "cursor": cursor, "sorts": tt.sorts}
+			if code := s.post("/sql-composer/cursor", body, &res); code != http.StatusOK {
+				t.Fatalf("%v: got %d", tt.sorts, code)
+			}
+			if res.Total != 3 {
+				t.Errorf("%v: total %d", tt.sorts, res.Total)
+			}
+			for _, row := range res.Data {
+				got = append(got, row.ID)
+			}
+			if cursor = res.NextCursor; cursor == "" {
+				break
+			}
+		}
+
+		if len(got) != len(tt.want) {
+			t.Errorf("%v: got %v, want %v", tt.sorts, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%v: got %v, want %v", tt.sorts, got, tt.want)
+				break
+			}
+		}
+	}
+
+	for _, format := range []string{formatCSV, formatXLSX, formatNDJSON} {
+		body := map[string]interface{}{"page_mode": "cursor", "page_limit": 2}
+		if code := s.post("/sql-composer/cursor?format="+format, body, nil); code != http.StatusBadRequest {
+			t.Errorf("cursor with %s: got %d, want 400", format, code)
+		}
+	}
+}
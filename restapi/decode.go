@@ -0,0 +1,272 @@
+package restapi
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"github.com/jmoiron/sqlx"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// columnKind is how the values of a result column are decoded
+type columnKind int
+
+const (
+	kindText columnKind = iota
+	kindInt
+	kindFloat
+	kindDecimal
+	kindBit
+	kindJSON
+	kindDateTime
+	kindDate
+	kindBinary
+)
+
+func columnKindOf(ct *sql.ColumnType) columnKind {
+	name := strings.ToUpper(ct.DatabaseTypeName())
+	// SQLite reports the declared type, e.g. DECIMAL(10, 2)
+	if i := strings.IndexByte(name, '('); i >= 0 {
+		name = strings.TrimSpace(name[:i])
+	}
+
+	switch name {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "INTEGER", "BIGINT", "YEAR", "INT2", "INT4", "INT8":
+		return kindInt
+	case "FLOAT", "DOUBLE", "REAL", "FLOAT4", "FLOAT8":
+		return kindFloat
+	case "DECIMAL", "NUMERIC", "MONEY", "SMALLMONEY":
+		return kindDecimal
+	case "BIT":
+		return kindBit
+	case "JSON", "JSONB":
+		return kindJSON
+	case "DATETIME", "TIMESTAMP", "TIMESTAMPTZ", "DATETIME2", "DATETIMEOFFSET", "SMALLDATETIME":
+		return kindDateTime
+	case "DATE":
+		return kindDate
+	case "BLOB", "TINYBLOB", "MEDIUMBLOB", "LONGBLOB", "BINARY", "VARBINARY", "BYTEA", "IMAGE":
+		return kindBinary
+	}
+	return kindText
+}
+
+const (
+	rowFormatObject = "object"
+	rowFormatArray  = "array"
+)
+
+type decodeOptions struct {
+	// Loc is the location of the datasource, text datetimes are read in it
+	Loc *time.Location
+	// DecimalAsString keeps decimals as strings instead of json numbers
+	DecimalAsString bool
+	// RowFormat is object (default) or array
+	RowFormat string
+	// Fields restricts the returned columns, nil returns all of them
+	Fields map[string]bool
+}
+
+// columnInfo describes a result column in select order
+type columnInfo struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Nullable bool   `json:"nullable"`
+}
+
+// rowDecoder turns scanned values into json friendly ones according to the
+// column types: numbers as numbers, json as nested values, datetimes as
+// RFC3339, binary as []byte (base64 in json) and NULL as nil.
+type rowDecoder struct {
+	columns []string
+	kinds   []columnKind
+	infos   []columnInfo
+	// shadowed marks columns hidden by a later column of the same name
+	shadowed []bool
+	// hidden marks columns left out by the requested fields
+	hidden []bool
+	opts   *decodeOptions
+}
+
+func newRowDecoder(rows *sqlx.Rows, opts *decodeOptions) (*rowDecoder, error) {
+	cts, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	o := *opts
+	if o.Loc == nil {
+		o.Loc = time.UTC
+	}
+
+	d := &rowDecoder{
+		columns:  make([]string, len(cts)),
+		kinds:    make([]columnKind, len(cts)),
+		shadowed: make([]bool, len(cts)),
+		hidden:   make([]bool, len(cts)),
+		opts:     &o,
+	}
+
+	seen := make(map[string]int, len(cts))
+	for i, ct := range cts {
+		d.columns[i] = ct.Name()
+		d.kinds[i] = columnKindOf(ct)
+
+		if o.Fields != nil && !o.Fields[ct.Name()] {
+			d.hidden[i] = true
+			continue
+		}
+
+		nullable, _ := ct.Nullable()
+		d.infos = append(d.infos, columnInfo{Name: ct.Name(), Type: ct.DatabaseTypeName(), Nullable: nullable})
+
+		if j, ok := seen[ct.Name()]; ok {
+			d.shadowed[j] = true
+		}
+		seen[ct.Name()] = i
+	}
+
+	return d, nil
+}
+
+// row decodes vals in the requested row format, an orderedRow or a plain array
+func (d *rowDecoder) row(vals []interface{}) interface{} {
+	for i, v := range vals {
+		if !d.hidden[i] {
+			vals[i] = d.value(i, v)
+		}
+	}
+
+	if d.opts.RowFormat == rowFormatArray {
+		visible := vals[:0]
+		for i, v := range vals {
+			if !d.hidden[i] {
+				visible = append(visible, v)
+			}
+		}
+		return visible
+	}
+
+	return &orderedRow{dec: d, values: vals}
+}
+
+// orderedRow is a row object keeping the select order of its keys in json.
+// A row streamed along the rows of other subjects names its subject in a
+// leading "_subject" key.
+type orderedRow struct {
+	dec     *rowDecoder
+	values  []interface{}
+	subject string
+}
+
+func (r *orderedRow) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+
+	buf.WriteByte('{')
+	first := true
+	if r.subject != "" {
+		k, err := json.Marshal(r.subject)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString(`"_subject":`)
+		buf.Write(k)
+		first = false
+	}
+	for i, v := range r.values {
+		if r.dec.shadowed[i] || r.dec.hidden[i] {
+			continue
+		}
+
+		if !first {
+			buf.WriteByte(',')
+		}
+		first = false
+
+		k, err := json.Marshal(r.dec.columns[i])
+		if err != nil {
+			return nil, err
+		}
+		val, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(val)
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+func (d *rowDecoder) value(i int, v interface{}) interface{} {
+	switch tv := v.(type) {
+	case nil:
+		return nil
+	case time.Time:
+		if d.kinds[i] == kindDate {
+			return tv.Format("2006-01-02")
+		}
+		return tv.Format(time.RFC3339Nano)
+	case []byte:
+		return d.bytesValue(d.kinds[i], tv)
+	case float64:
+		// SQLite reads decimals as floats, or integers when they are whole
+		if d.kinds[i] == kindDecimal && d.opts.DecimalAsString {
+			return strconv.FormatFloat(tv, 'f', -1, 64)
+		}
+	case int64:
+		if d.kinds[i] == kindDecimal && d.opts.DecimalAsString {
+			return strconv.FormatInt(tv, 10)
+		}
+	}
+	return v
+}
+
+// bytesValue decodes the text protocol representation of a value
+func (d *rowDecoder) bytesValue(kind columnKind, b []byte) interface{} {
+	s := string(b)
+
+	switch kind {
+	case kindInt:
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n
+		}
+		if n, err := strconv.ParseUint(s, 10, 64); err == nil {
+			return n
+		}
+	case kindFloat:
+		if n, err := strconv.ParseFloat(s, 64); err == nil {
+			return n
+		}
+	case kindDecimal:
+		if d.opts.DecimalAsString {
+			return s
+		}
+		return json.Number(s)
+	case kindBit:
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		return n
+	case kindJSON:
+		if json.Valid(b) {
+			return json.RawMessage(s)
+		}
+	case kindDateTime:
+		if t, err := time.ParseInLocation("2006-01-02 15:04:05.999999999", s, d.opts.Loc); err == nil {
+			return t.Format(time.RFC3339Nano)
+		}
+	case kindDate:
+		return s
+	case kindBinary:
+		return b
+	}
+
+	return s
+}
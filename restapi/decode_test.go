@@ -0,0 +1,129 @@
+package restapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDecodeBytesValue(t *testing.T) {
+	shanghai := time.FixedZone("CST", 8*3600)
+
+	tests := []struct {
+		kind columnKind
+		opts decodeOptions
+		b    string
+		want string
+	}{
+		{kindInt, decodeOptions{}, "42", `42`},
+		{kindInt, decodeOptions{}, "-9223372036854775808", `-9223372036854775808`},
+		{kindInt, decodeOptions{}, "18446744073709551615", `18446744073709551615`},
+		{kindFloat, decodeOptions{}, "1.5", `1.5`},
+		{kindDecimal, decodeOptions{}, "12345678901234567890.12", `12345678901234567890.12`},
+		{kindDecimal, decodeOptions{DecimalAsString: true}, "10.50", `"10.50"`},
+		{kindBit, decodeOptions{}, "\x01\x02", `258`},
+		{kindJSON, decodeOptions{}, `{"a":[1,2]}`, `{"a":[1,2]}`},
+		{kindJSON, decodeOptions{}, `{"a":`, `"{\"a\":"`},
+		{kindDateTime, decodeOptions{Loc: time.UTC}, "2020-08-01 13:04:05", `"2020-08-01T13:04:05Z"`},
+		{kindDateTime, decodeOptions{Loc: shanghai}, "2020-08-01 13:04:05.5", `"2020-08-01T13:04:05.5+08:00"`},
+		{kindDateTime, decodeOptions{Loc: time.UTC}, "0000-00-00", `"0000-00-00"`},
+		{kindDate, decodeOptions{}, "2020-08-01", `"2020-08-01"`},
+		{kindBinary, decodeOptions{}, "\x00\xff", `"AP8="`},
+		{kindText, decodeOptions{}, "007", `"007"`},
+	}
+	for _, tt := range tests {
+		opts := tt.opts
+		d := &rowDecoder{opts: &opts}
+		got, err := json.Marshal(d.bytesValue(tt.kind, []byte(tt.b)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("kind %d %q: got %s, want %s", tt.kind, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeValue(t *testing.T) {
+	d := &rowDecoder{kinds: []columnKind{kindDateTime, kindDate, kindInt, kindDecimal}, opts: &decodeOptions{Loc: time.UTC, DecimalAsString: true}}
+	at := time.Date(2020, 8, 1, 13, 4, 5, 0, time.FixedZone("CST", 8*3600))
+
+	tests := []struct {
+		i    int
+		v    interface{}
+		want interface{}
+	}{
+		{0, at, "2020-08-01T13:04:05+08:00"},
+		{1, at, "2020-08-01"},
+		{2, int64(7), int64(7)},
+		{2, nil, nil},
+		{3, 80.25, "80.25"},
+	}
+	for _, tt := range tests {
+		if got := d.value(tt.i, tt.v); got != tt.want {
+			t.Errorf("value(%d, %v) = %#v, want %#v", tt.i, tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestRowFormats(t *testing.T) {
+	s := newTestService(t)
+	defer s.Close()
+	s.addDoc("/rows", `
+info:
+  name: orders
+  version: 1.0.0
+composition:
+  subject:
+    data: "SELECT note, id, amount, status AS note FROM orders %where ORDER BY id %limit"
+`)
+
+	tests := []struct {
+		body map[string]interface{}
+		want string
+	}{
+		{
+			// a later column of the same name wins, keys keep the select order
+			map[string]interface{}{"page_index": 1, "page_limit": 2},
+			`[{"id":1,"amount":10.5,"note":"A"},{"id":2,"amount":120,"note":"B"}]`,
+		},
+		{
+			map[string]interface{}{"page_index": 1, "page_limit": 2, "decimal_as_string": true},
+			`[{"id":1,"amount":"10.5","note":"A"},{"id":2,"amount":"120","note":"B"}]`,
+		},
+		{
+			map[string]interface{}{"page_index": 1, "page_limit": 2, "row_format": rowFormatArray},
+			`[["x",1,10.5,"A"],[null,2,120,"B"]]`,
+		},
+	}
+	for _, tt := range tests {
+		var res struct {
+			Data    json.RawMessage         `json:"data"`
+			Columns map[string][]columnInfo `json:"columns"`
+		}
+		if code := s.post("/sql-composer/rows", tt.body, &res); code != http.StatusOK {
+			t.Fatalf("%v: got %d", tt.body, code)
+		}
+		if string(res.Data) != tt.want {
+			t.Errorf("%v: got %s, want %s", tt.body, res.Data, tt.want)
+		}
+
+		cols := res.Columns["data"]
+		if len(cols) != 4 {
+			t.Fatalf("%v: got columns %+v", tt.body, cols)
+		}
+		for i, name := range []string{"note", "id", "amount", "note"} {
+			if cols[i].Name != name {
+				t.Errorf("%v: column %d is %s, want %s", tt.body, i, cols[i].Name, name)
+			}
+		}
+		if cols[2].Type != "DECIMAL(10, 2)" {
+			t.Errorf("%v: amount is %s", tt.body, cols[2].Type)
+		}
+	}
+
+	if code := s.post("/sql-composer/rows", map[string]interface{}{"row_format": "table"}, nil); code != http.StatusBadRequest {
+		t.Errorf("unknown row format: got %d, want 400", code)
+	}
+}
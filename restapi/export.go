@@ -0,0 +1,101 @@
+package restapi
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/user/sqlcomposer-svc/composer"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	formatJSON = "json"
+	formatCSV  = "csv"
+)
+
+// exportFlushRows is how many rows are written between two flushes of a stream
+const exportFlushRows = 500
+
+// responseFormat resolves the output format from the format query param,
+// falling back to the Accept header.
+func responseFormat(c *gin.Context) string {
+	if f := strings.ToLower(c.Query("format")); f != "" {
+		return f
+	}
+
+	accept := c.GetHeader("Accept")
+	switch {
+	case strings.Contains(accept, "text/csv"):
+		return formatCSV
+	case strings.Contains(accept, xlsxContentType):
+		return formatXLSX
+	case strings.Contains(accept, ndjsonContentType):
+		return formatNDJSON
+	}
+
+	return formatJSON
+}
+
+// exportSubject picks the subject an export streams, the subject query param
+// or else the first subject that is not the total.
+func exportSubject(c *gin.Context, doc *composer.Doc) (string, error) {
+	if key := c.Query("subject"); key != "" {
+		if _, ok := doc.Composition.Subject[key]; !ok || key == "total" {
+			return "", fmt.Errorf("subject %s can not be exported", key)
+		}
+		return key, nil
+	}
+
+	for _, key := range doc.SubjectKeys() {
+		if key != "total" {
+			return key, nil
+		}
+	}
+
+	return "", fmt.Errorf("doc has no subject to export")
+}
+
+// exportLimit returns the limit of an export, every row unless the request pages.
+func exportLimit(req *SqlComposerRequest) (offset int64, size int64) {
+	if req.PageLimit > 0 {
+		return (req.PageIndex - 1) * req.PageLimit, req.PageLimit
+	}
+	return 0, math.MaxInt64
+}
+
+func exportFilename(path string, ext string) string {
+	name := strings.Replace(strings.Trim(path, "/"), "/", "_", -1)
+	if name == "" {
+		name = "export"
+	}
+	return fmt.Sprintf("%s.%s", name, ext)
+}
+
+// exportColumns returns the indexes of the columns to export, fields nil
+// exports all of them
+func exportColumns(cols []string, fields map[string]bool) []int {
+	idx := make([]int, 0, len(cols))
+	for i, name := range cols {
+		if fields == nil || fields[name] {
+			idx = append(idx, i)
+		}
+	}
+	return idx
+}
+
+// exportValue formats a scanned column value as text
+func exportValue(v interface{}) string {
+	switch tv := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(tv)
+	case string:
+		return tv
+	case time.Time:
+		return tv.Format("2006-01-02 15:04:05")
+	default:
+		return fmt.Sprint(tv)
+	}
+}
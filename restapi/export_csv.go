@@ -0,0 +1,89 @@
+package restapi
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/user/sqlcomposer-svc/datasource"
+	"net/http"
+)
+
+// writeCSV streams the rows of sq to the response, the first record holds the
+// column names. Rows are never collected in memory. fields restricts the
+// columns written, nil writes all of them.
+func writeCSV(c *gin.Context, src *datasource.Source, sq *subjectQuery, filename string, fields map[string]bool) {
+	sess, err := openSession(c.Request.Context(), src)
+	if err != nil {
+		log.Error(err)
+		c.JSON(queryErrorStatus(c.Request.Context()), errJSON(err))
+		return
+	}
+	defer sess.Close()
+
+	rows, err := sess.QueryxContext(c.Request.Context(), sq.SQL, sq.Args...)
+	if err != nil {
+		log.Error(err)
+		c.JSON(queryErrorStatus(c.Request.Context()), errJSONWithSQL(err, sq.SQL))
+		return
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		log.Error(err)
+		c.JSON(http.StatusBadRequest, errJSONWithSQL(err, sq.SQL))
+		return
+	}
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Status(http.StatusOK)
+
+	idx := exportColumns(cols, fields)
+	record := make([]string, len(idx))
+	for j, i := range idx {
+		record[j] = cols[i]
+	}
+
+	w := csv.NewWriter(c.Writer)
+	if err := w.Write(record); err != nil {
+		log.Error(err)
+		return
+	}
+
+	n := 0
+	for rows.Next() {
+		vals, err := rows.SliceScan()
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		for j, i := range idx {
+			record[j] = exportValue(vals[i])
+		}
+
+		if err := w.Write(record); err != nil {
+			log.Error(err)
+			return
+		}
+
+		n++
+		if n%exportFlushRows == 0 {
+			w.Flush()
+			c.Writer.Flush()
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		// the status is already sent, the truncated body is all we can do
+		log.WithField("sql", sq.SQL).Error(err)
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		log.Error(err)
+	}
+	c.Writer.Flush()
+}
@@ -0,0 +1,157 @@
+package restapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/user/sqlcomposer-svc/datasource"
+	"net/http"
+	"time"
+)
+
+const (
+	formatNDJSON = "ndjson"
+
+	ndjsonContentType = "application/x-ndjson"
+	// ndjsonSubjectKey names the subject of a line
+	ndjsonSubjectKey = "_subject"
+)
+
+// ndjsonMeta is the last line of a ndjson response
+type ndjsonMeta struct {
+	Total    *int64                  `json:"total,omitempty"`
+	Columns  map[string][]columnInfo `json:"columns,omitempty"`
+	SQL      map[string]string       `json:"sql,omitempty"`
+	ExecTime string                  `json:"exec_time"`
+	Err      string                  `json:"err,omitempty"`
+}
+
+// ndjsonArrayRow is a row of the array format in a ndjson response
+type ndjsonArrayRow struct {
+	Subject string      `json:"_subject"`
+	Row     interface{} `json:"row"`
+}
+
+// writeNDJSON writes every row of the subjects as one json line, flushing as
+// soon as a row is read, and ends with a {"_meta": ...} line. Every line names
+// its subject in a "_subject" key, a subject selecting a column of that name
+// is rejected in the object format. Once the first line is out errors can
+// only be reported in the meta line. With snapshot all statements read the
+// same snapshot.
+func writeNDJSON(c *gin.Context, src *datasource.Source, subjects []*subjectQuery, total *subjectQuery, snapshot bool, sqls map[string]string, opts *decodeOptions, start time.Time) {
+	ctx := c.Request.Context()
+	meta := &ndjsonMeta{SQL: sqls}
+	enc := json.NewEncoder(c.Writer)
+
+	var shared *session
+	if snapshot {
+		var err error
+		if shared, err = openSnapshot(ctx, src); err != nil {
+			log.Error(err)
+			c.JSON(queryErrorStatus(ctx), errJSON(err))
+			return
+		}
+		defer shared.Close()
+	}
+
+	started := false
+	begin := func() {
+		if !started {
+			c.Header("Content-Type", ndjsonContentType)
+			c.Status(http.StatusOK)
+			started = true
+		}
+	}
+
+	for _, sq := range subjects {
+		cols, err := writeNDJSONSubject(ctx, c, enc, src, shared, sq, opts, begin)
+
+		if cols != nil {
+			if meta.Columns == nil {
+				meta.Columns = make(map[string][]columnInfo)
+			}
+			meta.Columns[sq.Key] = cols
+		}
+
+		if err != nil {
+			log.WithField("sql", sq.SQL).Error(err)
+			if !started {
+				c.JSON(queryErrorStatus(ctx), errJSONWithSQL(err, sq.SQL))
+				return
+			}
+			meta.Err = err.Error()
+			break
+		}
+	}
+
+	if total != nil && meta.Err == "" {
+		if res, err := runSubjectIn(ctx, src, shared, total, opts); err != nil {
+			log.WithField("sql", total.SQL).Error(err)
+			meta.Err = err.Error()
+		} else {
+			meta.Total = &res.Total
+		}
+	}
+
+	begin()
+	meta.ExecTime = time.Since(start).String()
+	if err := enc.Encode(map[string]*ndjsonMeta{"_meta": meta}); err != nil {
+		log.Error(err)
+	}
+	c.Writer.Flush()
+}
+
+// writeNDJSONSubject streams the rows of one subject, begin is called once the
+// query succeeded so that a failing first query can still be answered with 400.
+func writeNDJSONSubject(ctx context.Context, c *gin.Context, enc *json.Encoder, src *datasource.Source, shared *session, sq *subjectQuery, opts *decodeOptions, begin func()) ([]columnInfo, error) {
+	sess, release, err := sessionFor(ctx, src, shared)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	rows, err := sess.QueryxContext(ctx, sq.SQL, sq.Args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	dec, err := newRowDecoder(rows, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.RowFormat != rowFormatArray {
+		for _, info := range dec.infos {
+			if info.Name == ndjsonSubjectKey {
+				return dec.infos, fmt.Errorf("subject %s has a column called %s, use the array row format or select it under another name", sq.Key, ndjsonSubjectKey)
+			}
+		}
+	}
+
+	begin()
+	for rows.Next() {
+		vals, err := rows.SliceScan()
+		if err != nil {
+			return dec.infos, err
+		}
+
+		var line interface{}
+		switch row := dec.row(vals).(type) {
+		case *orderedRow:
+			row.subject = sq.Key
+			line = row
+		default:
+			line = &ndjsonArrayRow{Subject: sq.Key, Row: row}
+		}
+
+		if err := enc.Encode(line); err != nil {
+			return dec.infos, err
+		}
+		c.Writer.Flush()
+	}
+
+	return dec.infos, rows.Err()
+}
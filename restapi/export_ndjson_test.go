@@ -0,0 +1,132 @@
+package restapi
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const ndjsonDoc = `
+info:
+  name: orders
+  version: 1.0.0
+composition:
+  subject:
+    total: "SELECT COUNT(*) FROM orders %where"
+    data: "SELECT id, status FROM orders %where %order_by %limit"
+    notes: "SELECT id, note FROM orders WHERE note IS NOT NULL ORDER BY id"
+sortable: [id]
+`
+
+const ndjsonSubjectColumnDoc = `
+info:
+  name: orders
+  version: 1.0.0
+composition:
+  subject:
+    data: "SELECT id, status AS _subject FROM orders %where %limit"
+`
+
+// ndjsonLines splits a ndjson body into its raw lines
+func ndjsonLines(t *testing.T, body string) []string {
+	var lines []string
+	sc := bufio.NewScanner(strings.NewReader(body))
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return lines
+}
+
+func TestWriteNDJSON(t *testing.T) {
+	s := newTestService(t)
+	defer s.Close()
+	s.addDoc("/ndjson", ndjsonDoc)
+
+	tests := []struct {
+		url    string
+		header map[string]string
+		want   []string
+	}{
+		{
+			url: "/sql-composer/ndjson?format=ndjson",
+			want: []string{
+				`{"_subject":"data","id":1,"status":"A"}`,
+				`{"_subject":"data","id":2,"status":"B"}`,
+				`{"_subject":"data","id":3,"status":"B"}`,
+				`{"_subject":"notes","id":1,"note":"x"}`,
+				`{"_subject":"notes","id":3,"note":"y"}`,
+			},
+		},
+		{
+			url:    "/sql-composer/ndjson",
+			header: map[string]string{"Accept": ndjsonContentType},
+			want: []string{
+				`{"_subject":"data","row":[1,"A"]}`,
+				`{"_subject":"data","row":[2,"B"]}`,
+				`{"_subject":"data","row":[3,"B"]}`,
+				`{"_subject":"notes","row":[1,"x"]}`,
+				`{"_subject":"notes","row":[3,"y"]}`,
+			},
+		},
+	}
+	for i, tt := range tests {
+		body := map[string]interface{}{"sorts": []map[string]string{{"field": "id", "dir": "ASC"}}}
+		if i == 1 {
+			body["row_format"] = rowFormatArray
+		}
+
+		w := s.send(tt.url, body, tt.header)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: got %d: %s", tt.url, w.Code, w.Body.String())
+		}
+		if ct := w.Header().Get("Content-Type"); ct != ndjsonContentType {
+			t.Errorf("%s: content type %q", tt.url, ct)
+		}
+
+		lines := ndjsonLines(t, w.Body.String())
+		if len(lines) != len(tt.want)+1 {
+			t.Fatalf("%s: got %v", tt.url, lines)
+		}
+		for j, want := range tt.want {
+			if lines[j] != want {
+				t.Errorf("%s: line %d is %s, want %s", tt.url, j, lines[j], want)
+			}
+		}
+
+		var meta struct {
+			Meta ndjsonMeta `json:"_meta"`
+		}
+		if err := json.Unmarshal([]byte(lines[len(lines)-1]), &meta); err != nil {
+			t.Fatal(err)
+		}
+		if meta.Meta.Total == nil || *meta.Meta.Total != 3 || meta.Meta.Err != "" || meta.Meta.ExecTime == "" {
+			t.Errorf("%s: got meta %+v", tt.url, meta.Meta)
+		}
+		if cols := meta.Meta.Columns["notes"]; len(cols) != 2 || cols[1].Name != "note" {
+			t.Errorf("%s: got columns %+v", tt.url, meta.Meta.Columns)
+		}
+	}
+}
+
+func TestWriteNDJSONSubjectColumn(t *testing.T) {
+	s := newTestService(t)
+	defer s.Close()
+	s.addDoc("/ndjson", ndjsonSubjectColumnDoc)
+
+	if w := s.send("/sql-composer/ndjson?format=ndjson", map[string]interface{}{}, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("object rows with a _subject column: got %d, want 400: %s", w.Code, w.Body.String())
+	}
+
+	w := s.send("/sql-composer/ndjson?format=ndjson", map[string]interface{}{"row_format": rowFormatArray}, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("array rows with a _subject column: got %d: %s", w.Code, w.Body.String())
+	}
+	if lines := ndjsonLines(t, w.Body.String()); len(lines) != 4 || lines[0] != `{"_subject":"data","row":[1,"A"]}` {
+		t.Errorf("got %v", lines)
+	}
+}
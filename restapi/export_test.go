@@ -0,0 +1,123 @@
+package restapi
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+const exportDoc = `
+info:
+  name: orders
+  version: 1.0.0
+composition:
+  subject:
+    total: "SELECT COUNT(*) FROM orders %where"
+    data: "SELECT id, status, note FROM orders %where %order_by %limit"
+sortable: [id]
+filters:
+  - attr: status
+    ops: ["="]
+`
+
+func TestExportValue(t *testing.T) {
+	tests := []struct {
+		v    interface{}
+		want string
+	}{
+		{nil, ""},
+		{[]byte("abc"), "abc"},
+		{"abc", "abc"},
+		{int64(42), "42"},
+		{10.5, "10.5"},
+		{true, "true"},
+		{time.Date(2020, 8, 1, 13, 4, 5, 0, time.UTC), "2020-08-01 13:04:05"},
+	}
+	for _, tt := range tests {
+		if got := exportValue(tt.v); got != tt.want {
+			t.Errorf("exportValue(%#v) = %q, want %q", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestExportFilename(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/orders", "orders.csv"},
+		{"/shop/orders/", "shop_orders.csv"},
+		{"/", "export.csv"},
+	}
+	for _, tt := range tests {
+		if got := exportFilename(tt.path, formatCSV); got != tt.want {
+			t.Errorf("exportFilename(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	s := newTestService(t)
+	defer s.Close()
+	s.addDoc("/export", exportDoc)
+
+	tests := []struct {
+		url    string
+		header map[string]string
+		body   map[string]interface{}
+		want   [][]string
+	}{
+		{
+			url:  "/sql-composer/export?format=csv",
+			body: map[string]interface{}{"sorts": []map[string]string{{"field": "id", "dir": "DESC"}}},
+			want: [][]string{{"id", "status", "note"}, {"3", "B", "y"}, {"2", "B", ""}, {"1", "A", "x"}},
+		},
+		{
+			url:    "/sql-composer/export",
+			header: map[string]string{"Accept": "text/csv"},
+			body: map[string]interface{}{
+				"filters": []map[string]interface{}{{"attr": "status", "op": "=", "val": "B"}},
+				"sorts":   []map[string]string{{"field": "id", "dir": "ASC"}},
+			},
+			want: [][]string{{"id", "status", "note"}, {"2", "B", ""}, {"3", "B", "y"}},
+		},
+		{
+			// paging applies only when asked for
+			url:  "/sql-composer/export?format=csv",
+			body: map[string]interface{}{"page_index": 2, "page_limit": 2, "sorts": []map[string]string{{"field": "id", "dir": "ASC"}}},
+			want: [][]string{{"id", "status", "note"}, {"3", "B", "y"}},
+		},
+	}
+	for _, tt := range tests {
+		w := s.send(tt.url, tt.body, tt.header)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: got %d: %s", tt.url, w.Code, w.Body.String())
+		}
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+			t.Errorf("%s: content type %q", tt.url, ct)
+		}
+		if cd := w.Header().Get("Content-Disposition"); !strings.Contains(cd, `filename="export.csv"`) {
+			t.Errorf("%s: content disposition %q", tt.url, cd)
+		}
+
+		got, err := csv.NewReader(w.Body).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.url, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if strings.Join(got[i], ",") != strings.Join(tt.want[i], ",") {
+				t.Errorf("%s: record %d is %v, want %v", tt.url, i, got[i], tt.want[i])
+			}
+		}
+	}
+
+	if w := s.send("/sql-composer/export?format=csv&subject=total", map[string]interface{}{}, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("exporting the total: got %d, want 400", w.Code)
+	}
+}
@@ -0,0 +1,356 @@
+package restapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/360EntSecGroup-Skylar/excelize/v2"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/user/sqlcomposer-svc/datasource"
+	"github.com/wangxb07/sqlcomposer"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	formatXLSX = "xlsx"
+
+	xlsxContentType  = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	xlsxSummarySheet = "summary"
+	xlsxDateFormat   = "yyyy-mm-dd hh:mm:ss"
+
+	xlsxMaxSheetName = 31
+	// xlsxMaxDigits is the precision of a number cell, larger integers and
+	// decimals are written as text
+	xlsxMaxDigits   = 15
+	xlsxMaxExactInt = 999999999999999
+)
+
+// xlsxExport is everything one workbook is made of. Each subject becomes a
+// sheet, the summary sheet lists the request and how long it took.
+type xlsxExport struct {
+	Path     string
+	Subjects []*subjectQuery
+	Total    *subjectQuery
+	Filters  []*SqlComposerFilterItem
+	Sorts    *sqlcomposer.OrderBy
+	Start    time.Time
+	// Fields restricts the exported columns, nil exports all of them
+	Fields map[string]bool
+	// Snapshot reads every sheet and the total from the same snapshot
+	Snapshot bool
+}
+
+type xlsxStyles struct {
+	header int
+	date   int
+}
+
+func writeXLSX(c *gin.Context, src *datasource.Source, ex *xlsxExport) {
+	f := excelize.NewFile()
+	f.SetSheetName("Sheet1", xlsxSummarySheet)
+
+	styles, err := newXLSXStyles(f)
+	if err != nil {
+		log.Error(err)
+		c.JSON(http.StatusInternalServerError, errJSON(err))
+		return
+	}
+
+	var shared *session
+	if ex.Snapshot {
+		if shared, err = openSnapshot(c.Request.Context(), src); err != nil {
+			log.Error(err)
+			c.JSON(queryErrorStatus(c.Request.Context()), errJSON(err))
+			return
+		}
+		defer shared.Close()
+	}
+
+	counts := make([]int, len(ex.Subjects))
+	sheets := map[string]bool{xlsxSummarySheet: true}
+	for i, sq := range ex.Subjects {
+		n, err := writeXLSXSheet(c, src, shared, f, styles, sq, xlsxSheetName(sq.Key, sheets), ex.Fields)
+		if err != nil {
+			log.Error(err)
+			c.JSON(queryErrorStatus(c.Request.Context()), errJSONWithSQL(err, sq.SQL))
+			return
+		}
+		counts[i] = n
+	}
+
+	var total interface{}
+	if ex.Total != nil {
+		res, err := runSubjectIn(c.Request.Context(), src, shared, ex.Total, &decodeOptions{})
+		if err != nil {
+			log.Error(err)
+			c.JSON(queryErrorStatus(c.Request.Context()), errJSONWithSQL(err, ex.Total.SQL))
+			return
+		}
+		total = res.Total
+	}
+
+	if err := writeXLSXSummary(f, styles, ex, counts, total); err != nil {
+		log.Error(err)
+		c.JSON(http.StatusInternalServerError, errJSON(err))
+		return
+	}
+
+	c.Header("Content-Type", xlsxContentType)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, exportFilename(ex.Path, formatXLSX)))
+	c.Status(http.StatusOK)
+
+	if err := f.Write(c.Writer); err != nil {
+		log.Error(err)
+	}
+}
+
+func newXLSXStyles(f *excelize.File) (*xlsxStyles, error) {
+	header, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return nil, err
+	}
+
+	dateFormat := xlsxDateFormat
+	date, err := f.NewStyle(&excelize.Style{CustomNumFmt: &dateFormat})
+	if err != nil {
+		return nil, err
+	}
+
+	return &xlsxStyles{header: header, date: date}, nil
+}
+
+// writeXLSXSheet writes the rows of sq to the sheet called name and returns
+// how many rows were written
+func writeXLSXSheet(c *gin.Context, src *datasource.Source, shared *session, f *excelize.File, styles *xlsxStyles, sq *subjectQuery, name string, fields map[string]bool) (int, error) {
+	f.NewSheet(name)
+
+	sw, err := f.NewStreamWriter(name)
+	if err != nil {
+		return 0, err
+	}
+
+	sess, release, err := sessionFor(c.Request.Context(), src, shared)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	rows, err := sess.QueryxContext(c.Request.Context(), sq.SQL, sq.Args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	cts, err := rows.ColumnTypes()
+	if err != nil {
+		return 0, err
+	}
+
+	cols := make([]string, len(cts))
+	for i, ct := range cts {
+		cols[i] = ct.Name()
+	}
+	idx := exportColumns(cols, fields)
+
+	header := make([]interface{}, len(idx))
+	kinds := make([]columnKind, len(idx))
+	for j, i := range idx {
+		header[j] = excelize.Cell{StyleID: styles.header, Value: cols[i]}
+		kinds[j] = columnKindOf(cts[i])
+	}
+	if err := sw.SetRow("A1", header); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for rows.Next() {
+		vals, err := rows.SliceScan()
+		if err != nil {
+			return n, err
+		}
+
+		cells := make([]interface{}, len(idx))
+		for j, i := range idx {
+			cells[j] = xlsxValue(kinds[j], vals[i], styles)
+		}
+
+		axis, _ := excelize.CoordinatesToCellName(1, n+2)
+		if err := sw.SetRow(axis, cells); err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+
+	return n, sw.Flush()
+}
+
+func writeXLSXSummary(f *excelize.File, styles *xlsxStyles, ex *xlsxExport, counts []int, total interface{}) error {
+	sw, err := f.NewStreamWriter(xlsxSummarySheet)
+	if err != nil {
+		return err
+	}
+
+	var lines [][]interface{}
+	lines = append(lines,
+		[]interface{}{"path", ex.Path},
+		[]interface{}{"generated_at", xlsxTime(time.Now(), styles)},
+		[]interface{}{"exec_time", time.Since(ex.Start).String()},
+	)
+
+	if total != nil {
+		lines = append(lines, []interface{}{"total", total})
+	}
+
+	for i, sq := range ex.Subjects {
+		lines = append(lines, []interface{}{"rows." + sq.Key, counts[i]})
+	}
+
+	lines = append(lines, []interface{}{})
+	lines = append(lines, []interface{}{
+		excelize.Cell{StyleID: styles.header, Value: "filter"},
+		excelize.Cell{StyleID: styles.header, Value: "op"},
+		excelize.Cell{StyleID: styles.header, Value: "val"},
+	})
+	for _, filter := range ex.Filters {
+		if filter.isGroup() {
+			conj, _ := filterConj(filter.Conj)
+			if filter.Not {
+				conj = "NOT " + conj
+			}
+			items, _ := json.Marshal(filter.Items)
+			lines = append(lines, []interface{}{"group", string(conj), string(items)})
+			continue
+		}
+
+		op := string(filter.Op)
+		if filter.Not {
+			op = "NOT " + op
+		}
+		val, _ := json.Marshal(filter.Val)
+		lines = append(lines, []interface{}{filter.Attr, op, string(val)})
+	}
+
+	if ex.Sorts != nil && !ex.Sorts.IsEmpty() {
+		lines = append(lines, []interface{}{})
+		lines = append(lines, []interface{}{
+			excelize.Cell{StyleID: styles.header, Value: "sort"},
+			excelize.Cell{StyleID: styles.header, Value: "direction"},
+		})
+		for _, s := range *ex.Sorts {
+			lines = append(lines, []interface{}{s.Name, string(s.Direction)})
+		}
+	}
+
+	for i, line := range lines {
+		axis, _ := excelize.CoordinatesToCellName(1, i+1)
+		if err := sw.SetRow(axis, line); err != nil {
+			return err
+		}
+	}
+
+	return sw.Flush()
+}
+
+// xlsxValue turns a scanned value into a typed cell so numbers and dates stay
+// numbers and dates in the sheet, everything else is written as text.
+func xlsxValue(kind columnKind, v interface{}, styles *xlsxStyles) interface{} {
+	b, ok := v.([]byte)
+	if !ok {
+		switch tv := v.(type) {
+		case time.Time:
+			return xlsxTime(tv, styles)
+		case int64:
+			if tv < -xlsxMaxExactInt || tv > xlsxMaxExactInt {
+				return strconv.FormatInt(tv, 10)
+			}
+		}
+		return v
+	}
+
+	s := string(b)
+	switch kind {
+	case kindInt:
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil && n >= -xlsxMaxExactInt && n <= xlsxMaxExactInt {
+			return n
+		}
+	case kindFloat:
+		if n, err := strconv.ParseFloat(s, 64); err == nil {
+			return n
+		}
+	case kindDecimal:
+		if xlsxExactDecimal(s) {
+			if n, err := strconv.ParseFloat(s, 64); err == nil {
+				return n
+			}
+		}
+	case kindDateTime:
+		if t, err := time.Parse("2006-01-02 15:04:05.999999999", s); err == nil {
+			return xlsxTime(t, styles)
+		}
+	case kindDate:
+		if t, err := time.Parse("2006-01-02", s); err == nil {
+			return xlsxTime(t, styles)
+		}
+	}
+
+	return s
+}
+
+// xlsxTime keeps the wall clock of t, cells have no time zone and excelize
+// only accepts UTC times.
+func xlsxTime(t time.Time, styles *xlsxStyles) excelize.Cell {
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	return excelize.Cell{StyleID: styles.date, Value: wall}
+}
+
+// xlsxExactDecimal tells whether the decimal s survives being written as a
+// number cell, cells are doubles and keep 15 significant digits.
+func xlsxExactDecimal(s string) bool {
+	digits := 0
+	for _, r := range strings.TrimLeft(strings.TrimLeft(s, "-+"), "0.") {
+		if r >= '0' && r <= '9' {
+			digits++
+		}
+	}
+	return digits <= xlsxMaxDigits
+}
+
+// xlsxSheetName makes a subject key a valid sheet name that is not in used yet
+// and adds it to used. Names are cut to 31 characters, a name that repeats is
+// given a numeric suffix.
+func xlsxSheetName(key string, used map[string]bool) string {
+	name := strings.NewReplacer(
+		":", "_", "\\", "_", "/", "_", "?", "_", "*", "_", "[", "_", "]", "_",
+	).Replace(key)
+
+	if name == xlsxSummarySheet {
+		name = "_" + name
+	}
+
+	base := name
+	for i := 2; ; i++ {
+		if r := []rune(name); len(r) > xlsxMaxSheetName {
+			name = string(r[:xlsxMaxSheetName])
+		}
+		// sheet names are case insensitive
+		if !used[strings.ToLower(name)] {
+			break
+		}
+
+		suffix := "_" + strconv.Itoa(i)
+		if r := []rune(base); len(r)+len(suffix) > xlsxMaxSheetName {
+			base = string(r[:xlsxMaxSheetName-len(suffix)])
+		}
+		name = base + suffix
+	}
+
+	used[strings.ToLower(name)] = true
+	return name
+}
@@ -0,0 +1,135 @@
+package restapi
+
+import (
+	"github.com/360EntSecGroup-Skylar/excelize/v2"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestXLSXSheetName(t *testing.T) {
+	used := map[string]bool{xlsxSummarySheet: true}
+
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"data", "data"},
+		{"Data", "Data_2"},
+		{"a/b:c", "a_b_c"},
+		{"summary", "_summary"},
+		{"SUMMARY", "SUMMARY_2"},
+		{strings.Repeat("x", 40), strings.Repeat("x", 31)},
+		{strings.Repeat("x", 35), strings.Repeat("x", 29) + "_2"},
+		{strings.Repeat("订", 40), strings.Repeat("订", 31)},
+	}
+	for _, tt := range tests {
+		if got := xlsxSheetName(tt.key, used); got != tt.want {
+			t.Errorf("xlsxSheetName(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestXLSXValue(t *testing.T) {
+	styles := &xlsxStyles{header: 1, date: 2}
+	at := time.Date(2020, 8, 1, 13, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		kind columnKind
+		v    interface{}
+		want interface{}
+	}{
+		{kindInt, []byte("42"), int64(42)},
+		{kindInt, []byte("1234567890123456789"), "1234567890123456789"},
+		{kindInt, int64(1234567890123456789), "1234567890123456789"},
+		{kindInt, int64(7), int64(7)},
+		{kindFloat, []byte("1.5"), 1.5},
+		{kindDecimal, []byte("80.25"), 80.25},
+		{kindDecimal, []byte("12345678901234567.89"), "12345678901234567.89"},
+		{kindText, []byte("007"), "007"},
+		{kindText, nil, nil},
+		{kindDate, []byte("2020-08-01"), excelize.Cell{StyleID: 2, Value: time.Date(2020, 8, 1, 0, 0, 0, 0, time.UTC)}},
+		{kindDateTime, []byte("2020-08-01 13:04:05"), excelize.Cell{StyleID: 2, Value: at}},
+		{kindDateTime, at.In(time.FixedZone("CST", 8*3600)), excelize.Cell{StyleID: 2, Value: time.Date(2020, 8, 1, 21, 4, 5, 0, time.UTC)}},
+	}
+	for _, tt := range tests {
+		got := xlsxValue(tt.kind, tt.v, styles)
+		if cell, ok := got.(excelize.Cell); ok {
+			want, ok := tt.want.(excelize.Cell)
+			if !ok || cell.StyleID != want.StyleID || !cell.Value.(time.Time).Equal(want.Value.(time.Time)) {
+				t.Errorf("xlsxValue(%v, %#v) = %#v, want %#v", tt.kind, tt.v, got, tt.want)
+			}
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("xlsxValue(%v, %#v) = %#v, want %#v", tt.kind, tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestXLSXExactDecimal(t *testing.T) {
+	tests := []struct {
+		s    string
+		want bool
+	}{
+		{"10.50", true},
+		{"-0.000123", true},
+		{"123456789012345", true},
+		{"1234567890123456", false},
+		{"0.1234567890123456", false},
+	}
+	for _, tt := range tests {
+		if got := xlsxExactDecimal(tt.s); got != tt.want {
+			t.Errorf("xlsxExactDecimal(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestWriteXLSX(t *testing.T) {
+	s := newTestService(t)
+	defer s.Close()
+	s.addDoc("/export", exportDoc)
+
+	body := map[string]interface{}{"sorts": []map[string]string{{"field": "id", "dir": "ASC"}}}
+	w := s.send("/sql-composer/export?format=xlsx", body, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+
+	f, err := excelize.OpenReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sheets := f.GetSheetList(); len(sheets) != 2 || sheets[0] != xlsxSummarySheet || sheets[1] != "data" {
+		t.Fatalf("got sheets %v", sheets)
+	}
+
+	rows, err := f.GetRows("data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"id,status,note", "1,A,x", "2,B,", "3,B,y"}
+	if len(rows) != len(want) {
+		t.Fatalf("got %v", rows)
+	}
+	for i := range rows {
+		if got := strings.Join(rows[i], ","); got != want[i] {
+			t.Errorf("row %d is %q, want %q", i, got, want[i])
+		}
+	}
+
+	summary, err := f.GetRows(xlsxSummarySheet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := map[string]string{}
+	for _, line := range summary {
+		if len(line) > 1 {
+			lines[line[0]] = line[1]
+		}
+	}
+	if lines["path"] != "/export" || lines["total"] != "3" || lines["rows.data"] != "3" || lines["id"] != "ASC" {
+		t.Errorf("got summary %v", summary)
+	}
+}
@@ -0,0 +1,88 @@
+package restapi
+
+import (
+	"context"
+	"fmt"
+	"github.com/user/sqlcomposer-svc/composer"
+	"github.com/user/sqlcomposer-svc/datasource"
+)
+
+// maxFacetLimit bounds the values returned for one facet
+const maxFacetLimit = 100
+
+// facetError reports why a requested facet was rejected
+type facetError struct {
+	Index int    `json:"index"`
+	Field string `json:"field"`
+	Err   string `json:"err"`
+}
+
+func validateFacets(doc *composer.Doc, names []string) []*facetError {
+	var errs []*facetError
+
+	seen := make(map[string]bool, len(names))
+	for i, name := range names {
+		if _, ok := doc.FacetSpec(name); !ok {
+			errs = append(errs, &facetError{Index: i, Field: name, Err: fmt.Sprintf("field %s has no facet", name)})
+			continue
+		}
+		if seen[name] {
+			errs = append(errs, &facetError{Index: i, Field: name, Err: fmt.Sprintf("facet %s is requested twice", name)})
+			continue
+		}
+		seen[name] = true
+	}
+
+	return errs
+}
+
+// facetQueries builds the value counts of the requested facets. Each facet is
+// counted over the data subject under every filter but the ones on its own
+// attribute, nested in groups or not.
+func facetQueries(ctx context.Context, compiled *composer.Compiled, src *datasource.Source, req *SqlComposerRequest, params map[string]interface{}) ([]*subjectQuery, error) {
+	doc := compiled.Doc
+
+	var queries []*subjectQuery
+	for _, name := range req.Facets {
+		spec, _ := doc.FacetSpec(name)
+
+		filters := withoutAttr(req.Filters, spec.Attr)
+
+		sb, err := compiled.NewBuilder(src.DB)
+		if err != nil {
+			return nil, err
+		}
+		// every builder binds the values of its own tokens
+		facetParams, err := configureSqlCompose(ctx, sb, src, params)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := addFilters(sb, filters); err != nil {
+			return nil, err
+		}
+
+		inner, args, err := rebindAll(doc, sb, composer.AggregateSubject, facetParams)
+		if err != nil {
+			return nil, err
+		}
+
+		limit := spec.Limit
+		if req.FacetLimit > 0 {
+			limit = req.FacetLimit
+		}
+
+		// value and count are keywords of some databases
+		value, count := src.Dialect.QuoteIdent("value"), src.Dialect.QuoteIdent("count")
+
+		queries = append(queries, &subjectQuery{
+			Key: "facet." + name,
+			SQL: fmt.Sprintf("SELECT %s AS %s, COUNT(*) AS %s FROM (%s) AS facet GROUP BY %s ORDER BY %s DESC, %s ASC %s",
+				name, value, count, inner, name, count, value, src.Dialect.Limit(0, int64(limit), true)),
+			Args:  args,
+			Facet: name,
+		})
+	}
+
+	return queries, nil
+}
@@ -0,0 +1,106 @@
+package restapi
+
+import (
+	"net/http"
+	"testing"
+)
+
+const facetDoc = `
+info:
+  name: orders
+  version: 1.0.0
+composition:
+  subject:
+    data: "SELECT id, status FROM orders %where %limit"
+facets:
+  - field: status
+`
+
+const facetWithoutDataDoc = `
+info:
+  name: orders
+  version: 1.0.0
+composition:
+  subject:
+    rows: "SELECT id, status FROM orders"
+facets:
+  - field: status
+`
+
+func TestFacets(t *testing.T) {
+	s := newTestService(t)
+	defer s.Close()
+	s.addDoc("/facets", facetDoc)
+	s.addDoc("/facets-without-data", facetWithoutDataDoc)
+
+	var res struct {
+		Facets map[string][]struct {
+			Value string `json:"value"`
+			Count int    `json:"count"`
+		} `json:"facets"`
+	}
+	body := map[string]interface{}{"page_index": 1, "page_limit": 10, "facets": []string{"status"}}
+	if code := s.post("/sql-composer/facets", body, &res); code != http.StatusOK {
+		t.Fatalf("got %d", code)
+	}
+
+	status := res.Facets["status"]
+	if len(status) != 2 || status[0].Value != "B" || status[0].Count != 2 || status[1].Value != "A" || status[1].Count != 1 {
+		t.Errorf("got %+v", status)
+	}
+
+	if code := s.post("/sql-composer/facets-without-data", body, nil); code != http.StatusBadRequest {
+		t.Errorf("doc without data subject: got %d, want 400", code)
+	}
+}
+
+func TestFacetExcludesNestedFilters(t *testing.T) {
+	s := newTestService(t)
+	defer s.Close()
+	s.addDoc("/facets", facetDoc)
+
+	var res struct {
+		Data   []map[string]interface{} `json:"data"`
+		Facets map[string][]struct {
+			Value string `json:"value"`
+			Count int    `json:"count"`
+		} `json:"facets"`
+	}
+	body := map[string]interface{}{
+		"page_index": 1,
+		"page_limit": 10,
+		"facets":     []string{"status"},
+		"filters": []interface{}{
+			group("and", leaf("id", ">", 1), group("or", leaf("status", "=", "A"), leaf("status", "=", "C"))),
+		},
+	}
+	if code := s.post("/sql-composer/facets", body, &res); code != http.StatusOK {
+		t.Fatalf("got %d", code)
+	}
+
+	if len(res.Data) != 0 {
+		t.Errorf("rows are not filtered: %v", res.Data)
+	}
+	status := res.Facets["status"]
+	if len(status) != 1 || status[0].Value != "B" || status[0].Count != 2 {
+		t.Errorf("got %+v, want the B orders with id > 1", status)
+	}
+}
+
+func TestWithoutAttr(t *testing.T) {
+	status := &SqlComposerFilterItem{Attr: "status", Op: "=", Val: "A"}
+	id := &SqlComposerFilterItem{Attr: "id", Op: ">", Val: 1}
+	onlyStatus := &SqlComposerFilterItem{Conj: "or", Items: []*SqlComposerFilterItem{status, status}}
+	mixed := &SqlComposerFilterItem{Not: true, Items: []*SqlComposerFilterItem{id, onlyStatus, status}}
+
+	got := withoutAttr([]*SqlComposerFilterItem{status, nil, mixed, onlyStatus}, "status")
+	if len(got) != 2 || got[0] != nil {
+		t.Fatalf("got %+v", got)
+	}
+	if g := got[1]; !g.Not || len(g.Items) != 1 || g.Items[0] != id {
+		t.Errorf("got group %+v", g)
+	}
+	if len(mixed.Items) != 3 {
+		t.Errorf("the request filters changed: %+v", mixed)
+	}
+}
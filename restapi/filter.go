@@ -0,0 +1,321 @@
+package restapi
+
+import (
+	"fmt"
+	"github.com/user/sqlcomposer-svc/composer"
+	"github.com/wangxb07/sqlcomposer"
+	"regexp"
+	"strings"
+)
+
+const (
+	// maxFilterDepth bounds the nesting of filter groups, the top level list is depth 1
+	maxFilterDepth = 5
+	// maxFilterItems bounds the filters and groups of a request
+	maxFilterItems = 100
+)
+
+// filterError reports why one item of the request filters was rejected. Path
+// holds the indexes leading to a nested item, starting with Index.
+type filterError struct {
+	Index int                  `json:"index"`
+	Path  []int                `json:"path,omitempty"`
+	Attr  string               `json:"attr"`
+	Op    sqlcomposer.Operator `json:"op"`
+	Err   string               `json:"err"`
+}
+
+func (f *SqlComposerFilterItem) isGroup() bool {
+	return f.Items != nil
+}
+
+// validateFilters checks the request filters against the whitelist of doc and
+// returns every offending item.
+func validateFilters(doc *composer.Doc, filters []*SqlComposerFilterItem) []*filterError {
+	var errs []*filterError
+
+	count := 0
+	var walk func(items []*SqlComposerFilterItem, path []int)
+	walk = func(items []*SqlComposerFilterItem, path []int) {
+		for i, f := range items {
+			count++
+
+			p := append(append([]int(nil), path...), i)
+			fe := &filterError{Index: p[0]}
+			if len(p) > 1 {
+				fe.Path = p
+			}
+
+			var err error
+			switch {
+			case f == nil:
+				err = fmt.Errorf("filter is null")
+			case f.isGroup():
+				err = validateFilterGroup(f, len(p))
+			default:
+				fe.Attr, fe.Op = f.Attr, f.Op
+				err = validateFilter(doc, f)
+			}
+
+			if err != nil {
+				fe.Err = err.Error()
+				errs = append(errs, fe)
+				continue
+			}
+
+			if f.isGroup() {
+				walk(f.Items, p)
+			}
+		}
+	}
+	walk(filters, nil)
+
+	if count > maxFilterItems {
+		return []*filterError{{Err: fmt.Sprintf("too many filters, at most %d are allowed", maxFilterItems)}}
+	}
+
+	return errs
+}
+
+func validateFilterGroup(f *SqlComposerFilterItem, depth int) error {
+	if f.Attr != "" || f.Op != "" {
+		return fmt.Errorf("a filter group can not have attr or op")
+	}
+	if len(f.Items) == 0 {
+		return fmt.Errorf("filter group is empty")
+	}
+	if depth >= maxFilterDepth {
+		return fmt.Errorf("filter groups nest deeper than %d levels", maxFilterDepth)
+	}
+	if _, err := filterConj(f.Conj); err != nil {
+		return err
+	}
+	return nil
+}
+
+func filterConj(conj string) (sqlcomposer.LogicOperator, error) {
+	switch strings.ToUpper(conj) {
+	case "", string(sqlcomposer.AND):
+		return sqlcomposer.AND, nil
+	case sqlcomposer.OR:
+		return sqlcomposer.OR, nil
+	}
+	return "", fmt.Errorf("invalid conj %q, expects and or or", conj)
+}
+
+func validateFilter(doc *composer.Doc, f *SqlComposerFilterItem) error {
+	if f.Conj != "" {
+		return fmt.Errorf("conj only applies to a filter group")
+	}
+	if !composer.IsOperator(f.Op) {
+		return fmt.Errorf("unknown operator %s", f.Op)
+	}
+
+	spec, ok := doc.FilterSpec(f.Attr)
+	if !ok {
+		return fmt.Errorf("attr %s is not filterable", f.Attr)
+	}
+	if !spec.Allows(f.Op) {
+		return fmt.Errorf("operator %s is not allowed on %s", f.Op, f.Attr)
+	}
+
+	switch f.Op {
+	case sqlcomposer.IsNull, sqlcomposer.IsNotNull:
+		return nil
+	case sqlcomposer.StartsWith, sqlcomposer.Contains, sqlcomposer.EndsWith:
+		if _, ok := f.Val.(string); !ok {
+			return fmt.Errorf("operator %s expects a string", f.Op)
+		}
+		return nil
+	case sqlcomposer.In, sqlcomposer.NotIn:
+		vals, ok := f.Val.([]interface{})
+		if !ok || len(vals) == 0 {
+			return fmt.Errorf("operator %s expects a non empty array", f.Op)
+		}
+		for _, v := range vals {
+			if err := composer.CheckValue(spec.Type, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	case sqlcomposer.Between, sqlcomposer.NotBetween:
+		vals, ok := f.Val.([]interface{})
+		if !ok || len(vals) != 2 {
+			return fmt.Errorf("operator %s expects an array of two values", f.Op)
+		}
+		for _, v := range vals {
+			if err := composer.CheckValue(spec.Type, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return composer.CheckValue(spec.Type, f.Val)
+}
+
+// withoutAttr returns filters without the filters on attr, wherever they are
+// nested. A group left without items is dropped, the others are copied. Null
+// items are kept for validation to report them.
+func withoutAttr(filters []*SqlComposerFilterItem, attr string) []*SqlComposerFilterItem {
+	var kept []*SqlComposerFilterItem
+	for _, f := range filters {
+		switch {
+		case f == nil:
+			kept = append(kept, f)
+		case f.isGroup():
+			items := withoutAttr(f.Items, attr)
+			if len(items) == 0 {
+				continue
+			}
+			g := *f
+			g.Items = items
+			kept = append(kept, &g)
+		case f.Attr != attr:
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// isPlain tells whether f is a filter sqlcomposer adds by itself
+func (f *SqlComposerFilterItem) isPlain() bool {
+	return !f.isGroup() && !f.Not
+}
+
+// addFilters adds the validated request filters to sb. Plain filters of the
+// top level go through sb.AddFilters as before so that %where[...] selections
+// keep working, every group and negated filter becomes one condition.
+func addFilters(sb *sqlcomposer.SqlBuilder, filters []*SqlComposerFilterItem) error {
+	var plain []sqlcomposer.Filter
+	for _, f := range filters {
+		if f.isPlain() {
+			plain = append(plain, sqlcomposer.Filter{Attr: f.Attr, Op: f.Op, Val: f.Val})
+		}
+	}
+
+	if err := sb.AddFilters(plain, sqlcomposer.AND); err != nil {
+		return err
+	}
+
+	fc := &filterCompiler{sb: sb, taken: make(map[string]bool)}
+	for k := range sb.Conditions.Arg {
+		fc.taken[k] = true
+	}
+
+	for _, f := range filters {
+		if f.isPlain() {
+			continue
+		}
+
+		clause, err := fc.item(f)
+		if err != nil {
+			return err
+		}
+
+		fc.n++
+		key := fmt.Sprintf("filter_group_%d", fc.n)
+		sb.AndConditions(&sqlcomposer.ConditionStmt{
+			Clause:      clause,
+			Arg:         fc.args,
+			ClauseSlice: map[string]string{key: clause},
+		})
+		fc.args = nil
+	}
+
+	return nil
+}
+
+// paramPattern matches the named parameters of a clause
+var paramPattern = regexp.MustCompile(`:[A-Za-z0-9_.]+`)
+
+// filterCompiler turns filter groups into one clause. sqlcomposer.Combine
+// renames clashing parameters by plain text replacement which breaks on
+// names sharing a prefix, so every parameter gets a name unique to the
+// request before the groups are combined with the builder conditions.
+type filterCompiler struct {
+	sb    *sqlcomposer.SqlBuilder
+	taken map[string]bool
+	args  map[string]interface{}
+	n     int
+}
+
+// item compiles a group or a filter, negated when it says so
+func (fc *filterCompiler) item(f *SqlComposerFilterItem) (string, error) {
+	var (
+		clause string
+		err    error
+	)
+	if f.isGroup() {
+		clause, err = fc.group(f)
+	} else {
+		clause, err = fc.filter(f)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if f.Not && clause != "" {
+		clause = "NOT (" + clause + ")"
+	}
+	return clause, nil
+}
+
+func (fc *filterCompiler) group(g *SqlComposerFilterItem) (string, error) {
+	conj, err := filterConj(g.Conj)
+	if err != nil {
+		return "", err
+	}
+
+	var terms []string
+	for _, f := range g.Items {
+		term, err := fc.item(f)
+		if err != nil {
+			return "", err
+		}
+		if term != "" {
+			terms = append(terms, "("+term+")")
+		}
+	}
+
+	return strings.Join(terms, fmt.Sprintf(" %s ", conj)), nil
+}
+
+// filter compiles one filter with the builder so pipelines apply as well
+func (fc *filterCompiler) filter(f *SqlComposerFilterItem) (string, error) {
+	saved := fc.sb.Conditions
+	defer fc.sb.SetConditions(saved)
+
+	fc.sb.SetConditions(&sqlcomposer.ConditionStmt{
+		Arg:         map[string]interface{}{},
+		ClauseSlice: map[string]string{},
+	})
+
+	err := fc.sb.AddFilters([]sqlcomposer.Filter{{Attr: f.Attr, Op: f.Op, Val: f.Val}}, sqlcomposer.AND)
+	if err != nil {
+		return "", err
+	}
+
+	stmt := fc.sb.Conditions
+	if fc.args == nil {
+		fc.args = make(map[string]interface{})
+	}
+
+	renamed := make(map[string]string, len(stmt.Arg))
+	for k, v := range stmt.Arg {
+		nk := k
+		for i := 1; fc.taken[nk]; i++ {
+			nk = fmt.Sprintf("%s_g%d", k, i)
+		}
+		fc.taken[nk] = true
+		fc.args[nk] = v
+		renamed[k] = nk
+	}
+
+	return paramPattern.ReplaceAllStringFunc(stmt.Clause, func(p string) string {
+		if nk, ok := renamed[p[1:]]; ok {
+			return ":" + nk
+		}
+		return p
+	}), nil
+}
@@ -0,0 +1,179 @@
+package restapi
+
+import (
+	"github.com/user/sqlcomposer-svc/composer"
+	"net/http"
+	"testing"
+)
+
+const filterDoc = `
+info:
+  name: orders
+  version: 1.0.0
+composition:
+  subject:
+    data: "SELECT id, status FROM orders %where ORDER BY id"
+filters:
+  - attr: status
+    ops: ["=", in]
+`
+
+const unfilteredDoc = `
+info:
+  name: orders
+  version: 1.0.0
+composition:
+  subject:
+    data: "SELECT id, status FROM orders %where ORDER BY id"
+`
+
+func TestFilterAttrMustBeDeclared(t *testing.T) {
+	s := newTestService(t)
+	defer s.Close()
+	s.addDoc("/filtered", filterDoc)
+	s.addDoc("/unfiltered", unfilteredDoc)
+
+	var res struct {
+		Data []map[string]interface{} `json:"data"`
+		Err  string                   `json:"err"`
+	}
+	code := s.post("/sql-composer/filtered", map[string]interface{}{
+		"filters": []map[string]interface{}{{"attr": "status", "op": "=", "val": "B"}},
+	}, &res)
+	if code != http.StatusOK || len(res.Data) != 2 {
+		t.Fatalf("declared filter: %d %+v", code, res)
+	}
+
+	injection := map[string]interface{}{
+		"filters": []map[string]interface{}{{"attr": "1=1) OR (1", "op": "=", "val": 1}},
+	}
+	for _, path := range []string{"/filtered", "/unfiltered"} {
+		res.Err = ""
+		if code := s.post("/sql-composer"+path, injection, &res); code != http.StatusBadRequest {
+			t.Errorf("%s: got %d %+v, want 400", path, code, res)
+		}
+	}
+
+	code = s.post("/sql-composer/filtered", map[string]interface{}{
+		"filters": []map[string]interface{}{{"attr": "id", "op": "=", "val": 1}},
+	}, nil)
+	if code != http.StatusBadRequest {
+		t.Errorf("undeclared filter: got %d, want 400", code)
+	}
+
+	// a doc without filters section accepts column names
+	res.Data = nil
+	code = s.post("/sql-composer/unfiltered", map[string]interface{}{
+		"filters": []map[string]interface{}{{"attr": "orders.status", "op": "=", "val": "B"}},
+	}, &res)
+	if code != http.StatusOK || len(res.Data) != 2 {
+		t.Errorf("filter of a doc without filters section: %d %+v", code, res)
+	}
+}
+
+func TestFilterSpec(t *testing.T) {
+	tests := []struct {
+		filters []composer.FilterSpec
+		attr    string
+		want    bool
+	}{
+		{nil, "status", true},
+		{nil, "orders.status", true},
+		{nil, "1=1) OR (1", false},
+		{nil, "status; DROP TABLE orders", false},
+		{[]composer.FilterSpec{}, "status", false},
+		{[]composer.FilterSpec{{Attr: "status"}}, "status", true},
+		{[]composer.FilterSpec{{Attr: "status"}}, "amount", false},
+	}
+	for _, tt := range tests {
+		doc := &composer.Doc{Filters: tt.filters}
+		if _, got := doc.FilterSpec(tt.attr); got != tt.want {
+			t.Errorf("filters %v: FilterSpec(%q) = %v, want %v", tt.filters, tt.attr, got, tt.want)
+		}
+	}
+}
+
+// leaf is a filter item of a request
+func leaf(attr, op string, val interface{}) map[string]interface{} {
+	return map[string]interface{}{"attr": attr, "op": op, "val": val}
+}
+
+// group is a filter group of a request
+func group(conj string, items ...interface{}) map[string]interface{} {
+	return map[string]interface{}{"conj": conj, "items": items}
+}
+
+// negate sets not on a filter or group
+func negate(f map[string]interface{}) map[string]interface{} {
+	f["not"] = true
+	return f
+}
+
+// nested is a group holding one filter under depth levels of groups
+func nested(depth int) map[string]interface{} {
+	f := leaf("id", "=", 1)
+	for i := 0; i < depth; i++ {
+		f = group("and", f)
+	}
+	return f
+}
+
+func TestFilterGroups(t *testing.T) {
+	s := newTestService(t)
+	defer s.Close()
+	s.addDoc("/unfiltered", unfilteredDoc)
+
+	many := func(n int) []interface{} {
+		items := make([]interface{}, n)
+		for i := range items {
+			items[i] = leaf("id", ">", 0)
+		}
+		return items
+	}
+
+	tests := []struct {
+		name    string
+		filters []interface{}
+		code    int
+		want    []int
+	}{
+		{"nested groups", []interface{}{group("or", leaf("status", "=", "A"), group("and", leaf("status", "=", "B"), leaf("id", "=", 3)))}, http.StatusOK, []int{1, 3}},
+		{"not group", []interface{}{negate(group("or", leaf("status", "=", "B")))}, http.StatusOK, []int{1}},
+		{"not filter", []interface{}{negate(leaf("status", "=", "B"))}, http.StatusOK, []int{1}},
+		{"not filter in group", []interface{}{group("or", leaf("id", "=", 2), negate(leaf("status", "=", "B")))}, http.StatusOK, []int{1, 2}},
+		{"not filter with plain filter", []interface{}{leaf("id", ">", 1), negate(leaf("note", "is_null", nil))}, http.StatusOK, []int{3}},
+		{"conj on a filter", []interface{}{map[string]interface{}{"attr": "id", "op": "=", "val": 1, "conj": "or"}}, http.StatusBadRequest, nil},
+		{"deepest group", []interface{}{nested(4)}, http.StatusOK, []int{1}},
+		{"too deep", []interface{}{nested(5)}, http.StatusBadRequest, nil},
+		{"most filters", many(maxFilterItems), http.StatusOK, []int{1, 2, 3}},
+		{"too many filters", many(maxFilterItems + 1), http.StatusBadRequest, nil},
+		{"too many nested filters", []interface{}{group("and", many(maxFilterItems)...)}, http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		var res struct {
+			Data []struct {
+				ID int `json:"id"`
+			} `json:"data"`
+		}
+		code := s.post("/sql-composer/unfiltered", map[string]interface{}{"filters": tt.filters}, &res)
+		if code != tt.code {
+			t.Errorf("%s: got %d, want %d", tt.name, code, tt.code)
+			continue
+		}
+
+		var got []int
+		for _, row := range res.Data {
+			got = append(got, row.ID)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package restapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/rubenv/sql-migrate"
+	"github.com/user/sqlcomposer-svc/composer"
+	"github.com/user/sqlcomposer-svc/datasource"
+	"github.com/user/sqlcomposer-svc/models"
+	"github.com/volatiletech/null/v8"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testOrders is the datasource of the tests, a SQLite database called lite
+const testOrders = `
+CREATE TABLE orders (id INTEGER PRIMARY KEY, status TEXT NOT NULL, amount DECIMAL(10, 2), note TEXT);
+INSERT INTO orders VALUES (1, 'A', 10.5, 'x'), (2, 'B', 120, NULL), (3, 'B', 80.25, 'y');
+`
+
+// testService is the service on a SQLite database with the lite datasource
+type testService struct {
+	t      *testing.T
+	db     *sqlx.DB
+	router *gin.Engine
+	dir    string
+}
+
+func newTestService(t *testing.T) *testService {
+	gin.SetMode(gin.TestMode)
+
+	dir, err := ioutil.TempDir("", "restapi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testService{t: t, dir: dir}
+
+	if s.db, err = sqlx.Connect("sqlite3", "file:"+filepath.Join(dir, "service.db")); err != nil {
+		s.Close()
+		t.Fatal(err)
+	}
+	migrations := &migrate.FileMigrationSource{Dir: "../migrations/sqlite3"}
+	if _, err := migrate.Exec(s.db.DB, "sqlite3", migrations, migrate.Up); err != nil {
+		s.Close()
+		t.Fatal(err)
+	}
+
+	lite := "file:" + filepath.Join(dir, "lite.db")
+	data, err := sqlx.Connect("sqlite3", lite)
+	if err != nil {
+		s.Close()
+		t.Fatal(err)
+	}
+	_, err = data.Exec(testOrders)
+	data.Close()
+	if err != nil {
+		s.Close()
+		t.Fatal(err)
+	}
+	s.exec("INSERT INTO database_config (name, dsn, driver) VALUES (?, ?, ?)", "lite", lite, datasource.DriverSQLite)
+
+	Setup(&Config{
+		DB:           s.db,
+		DataSources:  datasource.NewRegistry(&datasource.Config{MaxOpenConns: 2, MaxIdleConns: 1}),
+		Docs:         composer.NewCache(),
+		Dictionaries: datasource.NewDictionaries(time.Minute, 1),
+		Tokens:       composer.NewTokenCache(),
+	})
+	s.router = InitRoutes()
+
+	return s
+}
+
+func (s *testService) Close() {
+	if sources != nil {
+		sources.Close()
+	}
+	if s.db != nil {
+		s.db.Close()
+	}
+	os.RemoveAll(s.dir)
+}
+
+func (s *testService) exec(query string, args ...interface{}) {
+	if _, err := s.db.Exec(query, args...); err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+// testDoc compiles the doc content
+func testDoc(t *testing.T, content string) *composer.Doc {
+	cd, err := composer.Compile(&models.Doc{Content: null.StringFrom(content)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cd.Doc
+}
+
+// lite opens the lite datasource of the tests, the caller closes its DB
+func (s *testService) lite() *datasource.Source {
+	db, err := sqlx.Connect("sqlite3", "file:"+filepath.Join(s.dir, "lite.db"))
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return &datasource.Source{Name: "lite", DB: db, Dialect: s.liteDialect(), Loc: time.UTC}
+}
+
+func (s *testService) liteDialect() datasource.Dialect {
+	dialect, err := datasource.DialectOf(datasource.DriverSQLite)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return dialect
+}
+
+// addDoc stores a doc of the lite datasource served at path
+func (s *testService) addDoc(path, content string) {
+	s.exec("INSERT INTO doc (name, path, content, db_name) VALUES (?, ?, ?, ?)", path, path, content, "lite")
+}
+
+// post sends body as json and decodes the json response into res
+func (s *testService) post(url string, body interface{}, res interface{}) int {
+	w := s.send(url, body, nil)
+
+	if res != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), res); err != nil {
+			s.t.Fatalf("%s: %v: %s", url, err, w.Body.String())
+		}
+	}
+	return w.Code
+}
+
+// send posts body as json with the extra headers and returns the raw response
+func (s *testService) send(url string, body interface{}, header map[string]string) *httptest.ResponseRecorder {
+	b, err := json.Marshal(body)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, url, bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	s.router.ServeHTTP(w, req)
+
+	return w
+}
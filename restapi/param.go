@@ -0,0 +1,103 @@
+package restapi
+
+import (
+	"fmt"
+	"github.com/user/sqlcomposer-svc/composer"
+	"github.com/user/sqlcomposer-svc/datasource"
+	"github.com/wangxb07/sqlcomposer"
+	"sort"
+)
+
+// paramError reports why one param of the request was rejected
+type paramError struct {
+	Name string `json:"name"`
+	Err  string `json:"err"`
+}
+
+// bindParams validates the request params against the doc and returns the
+// placeholder args, keyed params.<name>. An optional param without value and
+// default is bound to NULL.
+func bindParams(doc *composer.Doc, supplied map[string]interface{}) (map[string]interface{}, []*paramError) {
+	var errs []*paramError
+	args := make(map[string]interface{}, len(doc.Params))
+
+	for i := range doc.Params {
+		ps := &doc.Params[i]
+
+		v, ok := supplied[ps.Name]
+		if !ok || v == nil {
+			if ps.Required {
+				errs = append(errs, &paramError{Name: ps.Name, Err: "param is required"})
+				continue
+			}
+			args[composer.ParamPrefix+ps.Name] = ps.Default
+			continue
+		}
+
+		if err := ps.Check(v); err != nil {
+			errs = append(errs, &paramError{Name: ps.Name, Err: err.Error()})
+			continue
+		}
+
+		if n, ok := composer.Number(v); ok && ps.Type == composer.TypeInt {
+			v = int64(n)
+		}
+		args[composer.ParamPrefix+ps.Name] = v
+	}
+
+	var unknown []string
+	for name := range supplied {
+		if !declaredParam(doc, name) {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		errs = append(errs, &paramError{Name: name, Err: fmt.Sprintf("unknown param %s", name)})
+	}
+
+	return args, errs
+}
+
+func declaredParam(doc *composer.Doc, name string) bool {
+	for _, ps := range doc.Params {
+		if ps.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// rebind builds the statement of key with the params bound, in the dialect of
+// the builder's database, and rejects it unless doc may run it. Params are set
+// right before binding since combining conditions drops the args of an empty
+// clause.
+func rebind(doc *composer.Doc, sb *sqlcomposer.SqlBuilder, key string, params map[string]interface{}) (string, []interface{}, error) {
+	for k, v := range params {
+		sb.Conditions.Arg[k] = v
+	}
+
+	dialect, err := datasource.DialectFor(sb.DB)
+	if err != nil {
+		return "", nil, err
+	}
+
+	q, args, err := sb.Rebind(key)
+	if err != nil {
+		return q, args, err
+	}
+	q = datasource.RewriteLimit(dialect, q)
+
+	if err := composer.CheckStatement(q, doc.Options.Mutation); err != nil {
+		return q, args, fmt.Errorf("subject %s: %v", key, err)
+	}
+
+	return q, args, nil
+}
+
+// rebindAll builds the statement of key like rebind but without its %limit
+// token, for the statements reading every row of the subject.
+func rebindAll(doc *composer.Doc, sb *sqlcomposer.SqlBuilder, key string, params map[string]interface{}) (string, []interface{}, error) {
+	sb.Doc = composer.WithoutLimit(sb.Doc, key)
+	return rebind(doc, sb, key, params)
+}
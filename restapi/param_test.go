@@ -0,0 +1,132 @@
+package restapi
+
+import (
+	"net/http"
+	"testing"
+)
+
+const paramDoc = `
+info:
+  name: orders
+  version: 1.0.0
+composition:
+  subject:
+    data: "SELECT id FROM orders WHERE status = :params.status AND id >= :params.min_id AND (:params.note IS NULL OR note = :params.note) ORDER BY id"
+params:
+  - name: status
+    type: string
+    enum: [A, B]
+    required: true
+  - name: min_id
+    type: int
+    default: 1
+    min: 1
+  - name: note
+    type: string
+`
+
+func TestBindParams(t *testing.T) {
+	doc := testDoc(t, paramDoc)
+
+	tests := []struct {
+		supplied map[string]interface{}
+		want     map[string]interface{}
+		errs     []string
+	}{
+		{
+			map[string]interface{}{"status": "A"},
+			map[string]interface{}{"params.status": "A", "params.min_id": 1, "params.note": nil},
+			nil,
+		},
+		{
+			// ints are bound as integers whatever json decoded them to
+			map[string]interface{}{"status": "B", "min_id": 3.0, "note": "y"},
+			map[string]interface{}{"params.status": "B", "params.min_id": int64(3), "params.note": "y"},
+			nil,
+		},
+		{
+			map[string]interface{}{"status": nil},
+			nil,
+			[]string{"status"},
+		},
+		{
+			map[string]interface{}{"status": "C", "min_id": 0.0, "limit": 1.0, "extra": true},
+			nil,
+			[]string{"status", "min_id", "extra", "limit"},
+		},
+		{
+			map[string]interface{}{"status": "A", "min_id": 1.5},
+			nil,
+			[]string{"min_id"},
+		},
+	}
+	for _, tt := range tests {
+		args, errs := bindParams(doc, tt.supplied)
+
+		if len(errs) != len(tt.errs) {
+			t.Errorf("%v: got errors %+v, want for %v", tt.supplied, errs, tt.errs)
+			continue
+		}
+		for i, e := range errs {
+			if e.Name != tt.errs[i] {
+				t.Errorf("%v: error %d is for %s, want %s", tt.supplied, i, e.Name, tt.errs[i])
+			}
+		}
+		if tt.errs != nil {
+			continue
+		}
+
+		if len(args) != len(tt.want) {
+			t.Errorf("%v: got %v, want %v", tt.supplied, args, tt.want)
+		}
+		for k, v := range tt.want {
+			if got, ok := args[k]; !ok || got != v {
+				t.Errorf("%v: %s is %#v, want %#v", tt.supplied, k, got, v)
+			}
+		}
+	}
+}
+
+func TestParamsQuery(t *testing.T) {
+	s := newTestService(t)
+	defer s.Close()
+	s.addDoc("/params", paramDoc)
+
+	tests := []struct {
+		params map[string]interface{}
+		code   int
+		want   []int
+	}{
+		{map[string]interface{}{"status": "B"}, http.StatusOK, []int{2, 3}},
+		{map[string]interface{}{"status": "B", "min_id": 3}, http.StatusOK, []int{3}},
+		{map[string]interface{}{"status": "B", "note": "y"}, http.StatusOK, []int{3}},
+		{map[string]interface{}{}, http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		var res struct {
+			Data []struct {
+				ID int `json:"id"`
+			} `json:"data"`
+			Details []paramError `json:"details"`
+		}
+		code := s.post("/sql-composer/params", map[string]interface{}{"params": tt.params}, &res)
+		if code != tt.code {
+			t.Errorf("%v: got %d, want %d", tt.params, code, tt.code)
+			continue
+		}
+		if code != http.StatusOK {
+			if len(res.Details) != 1 || res.Details[0].Name != "status" {
+				t.Errorf("%v: got details %+v", tt.params, res.Details)
+			}
+			continue
+		}
+
+		var got []int
+		for _, row := range res.Data {
+			got = append(got, row.ID)
+		}
+		if len(got) != len(tt.want) || got[0] != tt.want[0] {
+			t.Errorf("%v: got %v, want %v", tt.params, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package restapi
+
+import (
+	"fmt"
+	"github.com/user/sqlcomposer-svc/composer"
+)
+
+// fieldError reports why one requested field was rejected
+type fieldError struct {
+	Index int    `json:"index"`
+	Field string `json:"field"`
+	Err   string `json:"err"`
+}
+
+// validateFields checks the requested fields against the composition fields
+// of doc. It returns nil when no fields were requested, meaning all columns.
+func validateFields(doc *composer.Doc, names []string) (map[string]bool, []*fieldError) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	var errs []*fieldError
+	fields := make(map[string]bool, len(names))
+
+	for i, name := range names {
+		if _, ok := doc.Field(name); !ok {
+			errs = append(errs, &fieldError{Index: i, Field: name, Err: fmt.Sprintf("field %s is not declared by the doc", name)})
+			continue
+		}
+		fields[name] = true
+	}
+
+	return fields, errs
+}
+
+// selectFields is what the statements have to select for fields: the sorts
+// are ordered by alias and cursor pages read the sort values from the rows.
+func selectFields(doc *composer.Doc, fields map[string]bool, sorts []composer.SortSpec) map[string]bool {
+	selected := make(map[string]bool, len(fields)+len(sorts)+1)
+	for name := range fields {
+		selected[name] = true
+	}
+
+	for _, s := range sorts {
+		selected[s.Field] = true
+		selected[columnName(s.Field)] = true
+	}
+
+	if doc.Options.Cursor != nil && doc.Options.Cursor.Key != "" {
+		selected[doc.Options.Cursor.Key] = true
+		selected[columnName(doc.Options.Cursor.Key)] = true
+	}
+
+	return selected
+}
@@ -0,0 +1,98 @@
+package restapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+const projectionDoc = `
+info:
+  name: orders
+  version: 1.0.0
+composition:
+  fields:
+    base:
+      - name: id
+        expr: orders.id
+      - name: status
+        expr: orders.status
+    extra:
+      - name: note
+        expr: orders.note
+      - name: amount
+        expr: orders.amount
+  subject:
+    data: "SELECT %fields.base, %fields.extra FROM orders %where %order_by %limit"
+sortable: [id, amount, status]
+options:
+  cursor:
+    key: id
+`
+
+func TestFieldProjection(t *testing.T) {
+	s := newTestService(t)
+	defer s.Close()
+	s.addDoc("/projection", projectionDoc)
+
+	tests := []struct {
+		name string
+		body map[string]interface{}
+		want string
+	}{
+		{
+			"fields of both groups",
+			map[string]interface{}{"page_index": 1, "page_limit": 10, "fields": []string{"status", "amount"}},
+			`[{"status":"A","amount":10.5},{"status":"B","amount":120},{"status":"B","amount":80.25}]`,
+		},
+		{
+			// the first field of extra is selected to keep the statement valid, not returned
+			"group without requested fields",
+			map[string]interface{}{"page_index": 1, "page_limit": 10, "fields": []string{"id"}},
+			`[{"id":1},{"id":2},{"id":3}]`,
+		},
+		{
+			"sort on an unprojected field",
+			map[string]interface{}{"page_index": 1, "page_limit": 10, "fields": []string{"id"}, "sorts": []map[string]string{{"field": "amount", "dir": "DESC"}}},
+			`[{"id":2},{"id":3},{"id":1}]`,
+		},
+		{
+			"cursor on an unprojected sort",
+			map[string]interface{}{"fields": []string{"status"}, "page_mode": "cursor", "page_limit": 2, "sorts": []map[string]string{{"field": "amount", "dir": "ASC"}}},
+			`[{"status":"A"},{"status":"B"}]`,
+		},
+	}
+	for _, tt := range tests {
+		var res struct {
+			Data    json.RawMessage         `json:"data"`
+			Columns map[string][]columnInfo `json:"columns"`
+		}
+		if code := s.post("/sql-composer/projection", tt.body, &res); code != http.StatusOK {
+			t.Fatalf("%s: got %d", tt.name, code)
+		}
+		if string(res.Data) != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, res.Data, tt.want)
+		}
+
+		fields := map[string]bool{}
+		for _, f := range tt.body["fields"].([]string) {
+			fields[f] = true
+		}
+		for _, col := range res.Columns["data"] {
+			if !fields[col.Name] {
+				t.Errorf("%s: column %s is described", tt.name, col.Name)
+			}
+		}
+	}
+
+	var res struct {
+		Details []fieldError `json:"details"`
+	}
+	body := map[string]interface{}{"fields": []string{"id", "missing", "orders.id"}}
+	if code := s.post("/sql-composer/projection", body, &res); code != http.StatusBadRequest {
+		t.Fatalf("unknown fields: got %d, want 400", code)
+	}
+	if len(res.Details) != 2 || res.Details[0].Index != 1 || res.Details[1].Field != "orders.id" {
+		t.Errorf("unknown fields: got %+v", res.Details)
+	}
+}
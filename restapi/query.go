@@ -0,0 +1,201 @@
+package restapi
+
+import (
+	"context"
+	"github.com/user/sqlcomposer-svc/composer"
+	"github.com/user/sqlcomposer-svc/datasource"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// subjectQuery is a built statement of one composition subject
+type subjectQuery struct {
+	Key  string
+	SQL  string
+	Args []interface{}
+	// Keyset is set when the subject is paged by cursor
+	Keyset *keyset
+	// Facet is the field counted when the query is a facet
+	Facet string
+}
+
+type subjectResult struct {
+	Total      int64
+	Columns    []columnInfo
+	Rows       []interface{}
+	NextCursor string
+}
+
+// subjectError carries the statement that failed so it can be reported back
+type subjectError struct {
+	SQL string
+	Err error
+}
+
+func (e *subjectError) Error() string {
+	return e.Err.Error()
+}
+
+// queryTimeout is the timeout of a request on doc, the doc option or the
+// server default, capped by the server maximum.
+func queryTimeout(doc *composer.Doc) time.Duration {
+	timeout := doc.Options.Timeout
+	if timeout <= 0 {
+		timeout = defaultQueryTimeout
+	}
+	if maxQueryTimeout > 0 && (timeout <= 0 || timeout > maxQueryTimeout) {
+		timeout = maxQueryTimeout
+	}
+	return timeout
+}
+
+// queryErrorStatus is the status of a failed query, 504 when the request ran
+// out of time.
+func queryErrorStatus(ctx context.Context) int {
+	if ctx.Err() == context.DeadlineExceeded {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadRequest
+}
+
+// runSubjects executes the queries with at most concurrency of them in flight.
+// The first failure cancels the others, results are in the order of queries.
+// With snapshot the queries run one after the other in a single snapshot
+// session instead.
+func runSubjects(ctx context.Context, src *datasource.Source, queries []*subjectQuery, concurrency int, snapshot bool, opts *decodeOptions) ([]*subjectResult, error) {
+	if snapshot {
+		return runSnapshot(ctx, src, queries, opts)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+
+	results := make([]*subjectResult, len(queries))
+	sem := make(chan struct{}, concurrency)
+
+	for i, sq := range queries {
+		wg.Add(1)
+		go func(i int, sq *subjectQuery) {
+			defer wg.Done()
+
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				return
+			}
+
+			res, err := runSubjectIn(ctx, src, nil, sq, opts)
+			if err != nil {
+				once.Do(func() {
+					firstErr = &subjectError{SQL: sq.SQL, Err: err}
+					cancel()
+				})
+				return
+			}
+			results[i] = res
+		}(i, sq)
+	}
+
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	// the parent context ended before every subject got a slot
+	if err := ctx.Err(); err != nil {
+		for _, res := range results {
+			if res == nil {
+				return nil, err
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// runSnapshot executes the queries one after the other in a snapshot session
+func runSnapshot(ctx context.Context, src *datasource.Source, queries []*subjectQuery, opts *decodeOptions) ([]*subjectResult, error) {
+	sess, err := openSnapshot(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	defer sess.Close()
+
+	results := make([]*subjectResult, len(queries))
+	for i, sq := range queries {
+		res, err := runSubject(ctx, sess, sq, opts)
+		if err != nil {
+			return nil, &subjectError{SQL: sq.SQL, Err: err}
+		}
+		results[i] = res
+	}
+
+	return results, nil
+}
+
+// runSubjectIn executes sq in shared, or in a session of its own when shared
+// is nil
+func runSubjectIn(ctx context.Context, src *datasource.Source, shared *session, sq *subjectQuery, opts *decodeOptions) (*subjectResult, error) {
+	sess, release, err := sessionFor(ctx, src, shared)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return runSubject(ctx, sess, sq, opts)
+}
+
+func runSubject(ctx context.Context, sess *session, sq *subjectQuery, opts *decodeOptions) (*subjectResult, error) {
+	res := &subjectResult{}
+
+	if sq.Key == "total" {
+		err := sess.GetContext(ctx, &res.Total, sq.SQL, sq.Args...)
+		return res, err
+	}
+
+	rows, err := sess.QueryxContext(ctx, sq.SQL, sq.Args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if sq.Facet != "" {
+		// facets are {value, count} objects whatever the rows of the page look like
+		opts = &decodeOptions{Loc: opts.Loc, DecimalAsString: opts.DecimalAsString}
+	}
+
+	dec, err := newRowDecoder(rows, opts)
+	if err != nil {
+		return nil, err
+	}
+	res.Columns = dec.infos
+
+	for rows.Next() {
+		vals, err := rows.SliceScan()
+		if err != nil {
+			return nil, err
+		}
+
+		// the cursor points after the last row of the page and is built from
+		// the raw values, so they compare the same way in the seek predicate
+		if sq.Keyset != nil && int64(len(res.Rows)) == sq.Keyset.size-1 {
+			res.NextCursor, err = sq.Keyset.encode(dec.columns, vals)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		res.Rows = append(res.Rows, dec.row(vals))
+	}
+
+	return res, rows.Err()
+}
@@ -0,0 +1,130 @@
+package restapi
+
+import (
+	"context"
+	"github.com/user/sqlcomposer-svc/datasource"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestRunSubjects(t *testing.T) {
+	s := newTestService(t)
+	defer s.Close()
+	src := s.lite()
+	defer src.DB.Close()
+
+	queries := []*subjectQuery{
+		{Key: "total", SQL: "SELECT COUNT(*) FROM orders"},
+		{Key: "a", SQL: "SELECT id FROM orders WHERE status = ?", Args: []interface{}{"A"}},
+		{Key: "b", SQL: "SELECT id FROM orders WHERE status = ?", Args: []interface{}{"B"}},
+		{Key: "c", SQL: "SELECT id FROM orders WHERE id > ?", Args: []interface{}{2}},
+	}
+	opts := &decodeOptions{RowFormat: rowFormatArray}
+
+	tests := []struct {
+		concurrency int
+		snapshot    bool
+	}{
+		{1, false},
+		{2, false},
+		{8, false},
+		{1, true},
+	}
+	for _, tt := range tests {
+		results, err := runSubjects(context.Background(), src, queries, tt.concurrency, tt.snapshot, opts)
+		if err != nil {
+			t.Fatalf("concurrency %d, snapshot %v: %v", tt.concurrency, tt.snapshot, err)
+		}
+		if len(results) != len(queries) || results[0].Total != 3 {
+			t.Fatalf("concurrency %d, snapshot %v: got %+v", tt.concurrency, tt.snapshot, results)
+		}
+		// results are in the order of the queries whatever order they finished in
+		for i, want := range []int{1, 2, 1} {
+			if got := len(results[i+1].Rows); got != want {
+				t.Errorf("concurrency %d, snapshot %v: %s has %d rows, want %d", tt.concurrency, tt.snapshot, queries[i+1].Key, got, want)
+			}
+		}
+	}
+}
+
+func TestRunSubjectsFailure(t *testing.T) {
+	s := newTestService(t)
+	defer s.Close()
+	src := s.lite()
+	defer src.DB.Close()
+
+	queries := []*subjectQuery{
+		{Key: "data", SQL: "SELECT id FROM orders"},
+		{Key: "broken", SQL: "SELECT id FROM missing"},
+	}
+
+	_, err := runSubjects(context.Background(), src, queries, 2, false, &decodeOptions{})
+	serr, ok := err.(*subjectError)
+	if !ok {
+		t.Fatalf("got %v, want a subject error", err)
+	}
+	if serr.SQL != "SELECT id FROM missing" {
+		t.Errorf("error reports %q", serr.SQL)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := runSubjects(ctx, src, queries[:1], 1, false, &decodeOptions{}); err == nil {
+		t.Error("cancelled request succeeded")
+	}
+}
+
+// beginDialect is SQLite recording the kind of every read transaction begun
+type beginDialect struct {
+	datasource.Dialect
+	mu        *sync.Mutex
+	snapshots *[]bool
+}
+
+func (d beginDialect) Begin(snapshot bool) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	*d.snapshots = append(*d.snapshots, snapshot)
+	return d.Dialect.Begin(snapshot)
+}
+
+func TestRunSnapshot(t *testing.T) {
+	s := newTestService(t)
+	defer s.Close()
+	src := s.lite()
+	defer src.DB.Close()
+
+	queries := []*subjectQuery{
+		{Key: "total", SQL: "SELECT COUNT(*) FROM orders"},
+		{Key: "data", SQL: "SELECT id FROM orders"},
+		{Key: "paid", SQL: "SELECT id FROM orders WHERE status = ?", Args: []interface{}{"A"}},
+	}
+
+	tests := []struct {
+		snapshot bool
+		// begins lists the read transactions begun, one per session
+		begins []bool
+		// a snapshot holds a single connection
+		conns int
+	}{
+		{false, []bool{false, false, false}, 3},
+		{true, []bool{true}, 1},
+	}
+	for _, tt := range tests {
+		var snapshots []bool
+		src.Dialect = beginDialect{Dialect: s.liteDialect(), mu: &sync.Mutex{}, snapshots: &snapshots}
+		src.DB.SetMaxOpenConns(tt.conns)
+
+		results, err := runSubjects(context.Background(), src, queries, len(queries), tt.snapshot, &decodeOptions{RowFormat: rowFormatArray})
+		if err != nil {
+			t.Fatalf("snapshot %v: %v", tt.snapshot, err)
+		}
+		if results[0].Total != 3 || len(results[1].Rows) != 3 || len(results[2].Rows) != 1 {
+			t.Errorf("snapshot %v: got %+v", tt.snapshot, results)
+		}
+		if !reflect.DeepEqual(snapshots, tt.begins) {
+			t.Errorf("snapshot %v: began %v, want %v", tt.snapshot, snapshots, tt.begins)
+		}
+	}
+}
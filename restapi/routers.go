@@ -5,6 +5,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/user/sqlcomposer-svc/restapi/v1"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -43,9 +44,26 @@ func InitRoutes() *gin.Engine {
 		rv1.PATCH("/dsn/:id", v1.DSNUpdateHandler())
 		rv1.POST("/dsn", v1.DSNAddHandler())
 		rv1.DELETE("/dsn/:id", v1.DSNDeleteHandler())
+		rv1.DELETE("/dsn/:id/dictionary", v1.DSNDictionaryInvalidateHandler())
+
+		rv1.GET("/token", v1.TokenListHandler())
+		rv1.GET("/token/:id", v1.TokenGetHandler())
+		rv1.PATCH("/token/:id", v1.TokenUpdateHandler())
+		rv1.POST("/token", v1.TokenAddHandler())
+		rv1.DELETE("/token/:id", v1.TokenDeleteHandler())
 	}
 
-	router.POST("/sql-composer/*path", SqlComposerHandler())
+	composerHandler := SqlComposerHandler()
+	suggestHandler := SuggestHandler()
+	// a catch all route can not be followed by a static segment, suggestions
+	// are told apart by the suffix of the path
+	router.POST("/sql-composer/*path", func(c *gin.Context) {
+		if strings.HasSuffix(c.Param("path"), suggestSuffix) {
+			suggestHandler(c)
+			return
+		}
+		composerHandler(c)
+	})
 
 	return router
 }
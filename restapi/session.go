@@ -0,0 +1,184 @@
+package restapi
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+	"github.com/user/sqlcomposer-svc/composer"
+	"github.com/user/sqlcomposer-svc/datasource"
+	"time"
+)
+
+// killTimeout bounds the KILL QUERY issued for an abandoned statement and the
+// statements ending the read transaction of a session
+const killTimeout = 5 * time.Second
+
+// session runs statements on one dedicated connection. When ctx has a
+// deadline and ends before the session is closed, the statement running on
+// the server thread behind the connection is killed, a cancelled client
+// connection alone leaves it running on MySQL. Other drivers cancel the
+// statement by themselves.
+//
+// Reads run in a read only transaction, started with the first of them, so
+// that the database refuses a write hidden in a statement classified as read.
+type session struct {
+	conn     *sql.Conn
+	src      *datasource.Source
+	id       int64
+	snapshot bool
+	inTx     bool
+	done     chan struct{}
+	exited   chan struct{}
+}
+
+func openSession(ctx context.Context, src *datasource.Source) (*session, error) {
+	conn, err := src.DB.DB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &session{
+		conn:   conn,
+		src:    src,
+		done:   make(chan struct{}),
+		exited: make(chan struct{}),
+	}
+
+	// only a query that can run out of time is ever killed
+	_, deadline := ctx.Deadline()
+	q := src.Dialect.ConnectionID()
+	if !deadline || q == "" || src.Killer == nil {
+		close(s.exited)
+		return s, nil
+	}
+
+	if err := conn.QueryRowContext(ctx, q).Scan(&s.id); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	go s.watch(ctx)
+
+	return s, nil
+}
+
+// openSnapshot opens a session whose reads all see the same consistent
+// snapshot. Its statements must run one after the other.
+func openSnapshot(ctx context.Context, src *datasource.Source) (*session, error) {
+	s, err := openSession(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	s.snapshot = true
+	return s, nil
+}
+
+// sessionFor returns shared when it is set and opens a new session otherwise.
+// release closes only a session opened by sessionFor.
+func sessionFor(ctx context.Context, src *datasource.Source, shared *session) (sess *session, release func(), err error) {
+	if shared != nil {
+		return shared, func() {}, nil
+	}
+
+	sess, err = openSession(ctx, src)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sess, func() { sess.Close() }, nil
+}
+
+func (s *session) QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error) {
+	if !s.inTx && composer.IsRead(query) {
+		for _, q := range s.src.Dialect.Begin(s.snapshot) {
+			if _, err := s.conn.ExecContext(ctx, q); err != nil {
+				return nil, err
+			}
+		}
+		s.inTx = true
+	}
+
+	rows, err := s.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sqlx.Rows{Rows: rows, Mapper: s.src.DB.Mapper}, nil
+}
+
+// GetContext scans the single row of query into dest.
+func (s *session) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	rows, err := s.QueryxContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return sql.ErrNoRows
+	}
+	if err := rows.Scan(dest); err != nil {
+		return err
+	}
+
+	return rows.Close()
+}
+
+// watch kills the statement of the session when ctx ends first. The kill
+// runs on the kill pool of the source.
+func (s *session) watch(ctx context.Context) {
+	defer close(s.exited)
+
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		kctx, cancel := context.WithTimeout(context.Background(), killTimeout)
+		defer cancel()
+
+		logger := log.WithField("connection_id", s.id)
+		if _, err := s.src.Killer.ExecContext(kctx, s.src.Dialect.Kill(s.id)); err != nil {
+			logger.WithError(err).Error("query kill failed, it may still run on the server")
+			return
+		}
+		logger.Warn("query killed, ", ctx.Err())
+	}
+}
+
+// Close ends the read transaction and returns the connection to the pool. It
+// waits for a pending kill so that it can never hit a statement of the next
+// user of the connection. A connection whose transaction could not be ended
+// is discarded instead, the next user would read in it.
+func (s *session) Close() error {
+	close(s.done)
+	<-s.exited
+
+	if s.inTx {
+		ctx, cancel := context.WithTimeout(context.Background(), killTimeout)
+		defer cancel()
+
+		for _, q := range s.src.Dialect.End(s.snapshot) {
+			if _, err := s.conn.ExecContext(ctx, q); err != nil {
+				// a connection broken by a cancelled statement is already dropped
+				if err != driver.ErrBadConn {
+					log.WithField("connection_id", s.id).Error(err)
+				}
+				return s.discard()
+			}
+		}
+	}
+
+	return s.conn.Close()
+}
+
+// discard closes the connection for good instead of returning it to the pool
+func (s *session) discard() error {
+	err := s.conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	if err == driver.ErrBadConn || err == sql.ErrConnDone {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,152 @@
+package restapi
+
+import (
+	"context"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/user/sqlcomposer-svc/datasource"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// killDialect is SQLite with a connection id, a kill records the id in the
+// kills table of the kill pool
+type killDialect struct {
+	datasource.Dialect
+	connectionID string
+}
+
+func (d killDialect) ConnectionID() string { return d.connectionID }
+
+func (d killDialect) Kill(id int64) string {
+	return fmt.Sprintf("INSERT INTO kills VALUES (%d)", id)
+}
+
+func TestSessionKill(t *testing.T) {
+	s := newTestService(t)
+	defer s.Close()
+	src := s.lite()
+	defer src.DB.Close()
+
+	// the only connection of the pool is held by the session to kill
+	src.DB.SetMaxOpenConns(1)
+
+	killer, err := sqlx.Connect("sqlite3", "file:"+filepath.Join(s.dir, "kills.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer killer.Close()
+	if _, err := killer.Exec("CREATE TABLE kills (id INTEGER)"); err != nil {
+		t.Fatal(err)
+	}
+	src.Killer = killer
+
+	kills := func() []int64 {
+		var ids []int64
+		if err := killer.Select(&ids, "SELECT id FROM kills"); err != nil {
+			t.Fatal(err)
+		}
+		killer.MustExec("DELETE FROM kills")
+		return ids
+	}
+
+	tests := []struct {
+		name     string
+		timeout  time.Duration
+		wait     time.Duration
+		wantKill bool
+	}{
+		{"no timeout", 0, 20 * time.Millisecond, false},
+		{"done in time", time.Second, 0, false},
+		{"timed out", 10 * time.Millisecond, 50 * time.Millisecond, true},
+	}
+	for _, tt := range tests {
+		src.Dialect = killDialect{Dialect: s.liteDialect(), connectionID: "SELECT 42"}
+
+		ctx, cancel := context.Background(), func() {}
+		if tt.timeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+		}
+
+		sess, err := openSession(ctx, src)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var n int
+		if err := sess.GetContext(ctx, &n, "SELECT COUNT(*) FROM orders"); err != nil || n != 3 {
+			t.Fatalf("%s: got %d, %v", tt.name, n, err)
+		}
+		time.Sleep(tt.wait)
+		sess.Close()
+		cancel()
+
+		ids := kills()
+		if tt.wantKill && (len(ids) != 1 || ids[0] != 42) {
+			t.Errorf("%s: killed %v, want 42", tt.name, ids)
+		}
+		if !tt.wantKill && len(ids) > 0 {
+			t.Errorf("%s: killed %v", tt.name, ids)
+		}
+	}
+
+	// the connection id is only read when the session can time out
+	src.Dialect = killDialect{Dialect: s.liteDialect(), connectionID: "SELECT id FROM missing"}
+	sess, err := openSession(context.Background(), src)
+	if err != nil {
+		t.Fatalf("without timeout: %v", err)
+	}
+	sess.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := openSession(ctx, src); err == nil {
+		t.Error("with timeout: read a missing connection id")
+	}
+}
+
+// endDialect is SQLite whose read transaction can not be ended
+type endDialect struct {
+	datasource.Dialect
+}
+
+func (endDialect) End(snapshot bool) []string { return []string{"ROLLBACK TO missing"} }
+
+func TestSessionCloseDiscards(t *testing.T) {
+	s := newTestService(t)
+	defer s.Close()
+	src := s.lite()
+	defer src.DB.Close()
+
+	tests := []struct {
+		name    string
+		dialect datasource.Dialect
+		read    bool
+		want    int
+	}{
+		{"ended", s.liteDialect(), true, 1},
+		{"not in a transaction", endDialect{s.liteDialect()}, false, 1},
+		{"end failed", endDialect{s.liteDialect()}, true, 0},
+	}
+	for _, tt := range tests {
+		src.Dialect = tt.dialect
+
+		sess, err := openSession(context.Background(), src)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if tt.read {
+			var n int
+			if err := sess.GetContext(context.Background(), &n, "SELECT COUNT(*) FROM orders"); err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+		}
+		if err := sess.Close(); err != nil {
+			t.Errorf("%s: close: %v", tt.name, err)
+		}
+
+		if got := src.DB.Stats().OpenConnections; got != tt.want {
+			t.Errorf("%s: %d open connections, want %d", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package restapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/user/sqlcomposer-svc/composer"
+)
+
+// SortItem is a sort entry of the request, either a ["field", "dir"] tuple or
+// a {"field": .., "dir": .., "nulls": "first|last"} object.
+type SortItem struct {
+	composer.SortSpec
+
+	// invalid keeps why the entry could not be read, it is reported along
+	// with the other sort errors instead of failing the whole request body
+	invalid string
+}
+
+func (si *SortItem) UnmarshalJSON(b []byte) error {
+	var tuple []string
+	if err := json.Unmarshal(b, &tuple); err == nil {
+		if len(tuple) < 1 || len(tuple) > 2 {
+			si.invalid = "sort tuple expects [field] or [field, dir]"
+			return nil
+		}
+		si.Field = tuple[0]
+		if len(tuple) == 2 {
+			si.Dir = tuple[1]
+		}
+		return nil
+	}
+
+	if err := json.Unmarshal(b, &si.SortSpec); err != nil {
+		si.invalid = "sort expects a [field, dir] tuple or a {field, dir, nulls} object"
+	}
+	return nil
+}
+
+// sortError reports why one sort entry of the request was rejected
+type sortError struct {
+	Index int    `json:"index"`
+	Field string `json:"field,omitempty"`
+	Err   string `json:"err"`
+}
+
+// validateSorts normalizes the request sorts against the sortable list of doc
+// and falls back to the default sort of the doc when there are none.
+func validateSorts(doc *composer.Doc, items []*SortItem) ([]composer.SortSpec, []*sortError) {
+	if len(items) == 0 {
+		return doc.Options.DefaultSort, nil
+	}
+
+	var (
+		specs []composer.SortSpec
+		errs  []*sortError
+	)
+
+	for i, si := range items {
+		if si == nil {
+			errs = append(errs, &sortError{Index: i, Err: "sort is null"})
+			continue
+		}
+		if si.invalid != "" {
+			errs = append(errs, &sortError{Index: i, Err: si.invalid})
+			continue
+		}
+
+		spec, err := si.Normalize()
+		if err != nil {
+			errs = append(errs, &sortError{Index: i, Field: si.Field, Err: err.Error()})
+			continue
+		}
+
+		if !doc.IsSortable(spec.Field) {
+			errs = append(errs, &sortError{Index: i, Field: si.Field, Err: fmt.Sprintf("field %s is not sortable", spec.Field)})
+			continue
+		}
+
+		specs = append(specs, spec)
+	}
+
+	return specs, errs
+}
@@ -0,0 +1,37 @@
+package restapi
+
+import (
+	"github.com/user/sqlcomposer-svc/composer"
+	"testing"
+)
+
+func TestValidateSorts(t *testing.T) {
+	doc := &composer.Doc{}
+	doc.Options.DefaultSort = []composer.SortSpec{{Field: "id", Dir: "ASC"}}
+
+	if specs, errs := validateSorts(doc, nil); len(errs) > 0 || len(specs) != 1 || specs[0].Field != "id" {
+		t.Errorf("default sort: got %v %v", specs, errs)
+	}
+
+	tests := []struct {
+		sortable []string
+		field    string
+		want     bool
+	}{
+		{nil, "amount", true},
+		{nil, "orders.amount", true},
+		{nil, "amount; DROP TABLE orders", false},
+		{nil, "(SELECT 1)", false},
+		{[]string{}, "amount", false},
+		{[]string{"amount"}, "amount", true},
+		{[]string{"amount"}, "id", false},
+	}
+	for _, tt := range tests {
+		doc.Sortable = tt.sortable
+		items := []*SortItem{{SortSpec: composer.SortSpec{Field: tt.field}}}
+		specs, errs := validateSorts(doc, items)
+		if got := len(errs) == 0 && len(specs) == 1; got != tt.want {
+			t.Errorf("sortable %v: sort on %q got %v %v", tt.sortable, tt.field, specs, errs)
+		}
+	}
+}
@@ -1,45 +1,96 @@
 package restapi
 
 import (
-	"database/sql"
+	"context"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/jmoiron/sqlx"
 	log "github.com/sirupsen/logrus"
+	"github.com/user/sqlcomposer-svc/composer"
+	"github.com/user/sqlcomposer-svc/datasource"
 	"github.com/user/sqlcomposer-svc/models"
 	"github.com/volatiletech/sqlboiler/v4/queries/qm"
 	"github.com/wangxb07/sqlcomposer"
-	"gopkg.in/yaml.v2"
 	"net/http"
-	"os"
 	"sort"
 	"strings"
-	"sync"
 	"time"
 )
 
 type SqlComposerRequest struct {
-	PageIndex int64                    `json:"page_index"`
-	PageLimit int64                    `json:"page_limit"`
-	Filters   []*SqlComposerFilterItem `json:"filters"`
-	Sorts     [][]string               `json:"sorts"`
+	PageIndex int64 `json:"page_index"`
+	PageLimit int64 `json:"page_limit"`
+	// PageMode is offset (default) or cursor, a cursor implies the cursor mode
+	PageMode string                   `json:"page_mode"`
+	Cursor   string                   `json:"cursor"`
+	Filters  []*SqlComposerFilterItem `json:"filters"`
+	Sorts    []*SortItem              `json:"sorts"`
+	// DecimalAsString returns decimal columns as strings to keep their precision
+	DecimalAsString bool `json:"decimal_as_string"`
+	// RowFormat is object (default) or array, array rows follow the columns descriptor
+	RowFormat string `json:"row_format"`
+	// Params supplies the params declared by the doc
+	Params map[string]interface{} `json:"params"`
+	// Fields restricts the returned columns to these composition fields
+	Fields []string `json:"fields"`
+	// Aggregate groups the rows of the data subject instead of listing them
+	Aggregate *AggregateRequest `json:"aggregate"`
+	// Facets requests the value counts of these fields along with the rows
+	Facets []string `json:"facets"`
+	// FacetLimit overrides how many values of each facet are returned
+	FacetLimit int `json:"facet_limit"`
 }
 
+// SqlComposerFilterItem is a filter on attr, or a group of items when Items is
+// set. Items of a group are joined by Conj (and by default), Not negates it.
 type SqlComposerFilterItem struct {
 	Attr string               `json:"attr"`
 	Op   sqlcomposer.Operator `json:"op"`
 	Val  interface{}          `json:"val"`
+
+	// Conj joins the Items of a group, and (default) or or
+	Conj string `json:"conj,omitempty"`
+	// Not negates a group or a single filter
+	Not   bool                     `json:"not,omitempty"`
+	Items []*SqlComposerFilterItem `json:"items,omitempty"`
 }
 
-var db *sqlx.DB
+var (
+	db           *sqlx.DB
+	sources      *datasource.Registry
+	docs         *composer.Cache
+	dictionaries *datasource.Dictionaries
+	storedTokens *composer.TokenCache
+
+	defaultQueryTimeout time.Duration
+	maxQueryTimeout     time.Duration
+)
 
 type Config struct {
-	DB *sqlx.DB
+	DB          *sqlx.DB
+	DataSources *datasource.Registry
+	Docs        *composer.Cache
+	// Dictionaries resolves the attributes of the attrs tokens
+	Dictionaries *datasource.Dictionaries
+	// Tokens compiles the tokens of the token table
+	Tokens *composer.TokenCache
+	// QueryTimeout applies to docs without options.timeout, 0 means no timeout
+	QueryTimeout time.Duration
+	// QueryMaxTimeout caps the timeout of every doc, 0 means no cap
+	QueryMaxTimeout time.Duration
 }
 
 func Setup(cfg *Config) {
 	//init db
 	db = cfg.DB
+	sources = cfg.DataSources
+	docs = cfg.Docs
+	dictionaries = cfg.Dictionaries
+	storedTokens = cfg.Tokens
+	suggestions = newSuggestCache()
+	sources.OnEvict(suggestions.invalidate)
+	defaultQueryTimeout = cfg.QueryTimeout
+	maxQueryTimeout = cfg.QueryMaxTimeout
 }
 
 func errJSON(err error) map[string]interface{} {
@@ -55,14 +106,24 @@ func errJSONWithSQL(err error, s string) map[string]interface{} {
 	}
 }
 
+func errJSONWithDetails(err error, details interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"err":     err.Error(),
+		"details": details,
+	}
+}
+
 // @Summary 获取查询结果
 // @Tags 接口
 // @version 1.0
 // @Param path path string true "path"
 // @Param debug query string true "debug"
+// @Param format query string false "json, csv, xlsx or ndjson, defaults to the Accept header"
+// @Param subject query string false "subject to export, csv only"
 // @Success 200 {string} string	"json"
 // @Failure 400 {object} Error "error"
 // @Failure 404 {object} Error "not found"
+// @Failure 504 {object} Error "query timeout"
 // @Router /{path} [get]
 func SqlComposerHandler() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -86,39 +147,83 @@ func SqlComposerHandler() gin.HandlerFunc {
 			return
 		}
 
-		var custFilters []sqlcomposer.Filter
-		for _, filter := range req.Filters {
-			custFilter := sqlcomposer.Filter{
-				Val:  filter.Val,
-				Op:   filter.Op,
-				Attr: filter.Attr,
-			}
-			custFilters = append(custFilters, custFilter)
+		compiled, err := docs.Get(docFound)
+		if err != nil {
+			log.Error(err)
+			c.JSON(http.StatusBadRequest, errJSON(err))
+			return
 		}
 
-		sorts := &sqlcomposer.OrderBy{}
-		for _, s := range req.Sorts {
-			var d sqlcomposer.Direction
+		doc := compiled.Doc
 
-			d = sqlcomposer.DESC
+		if errs := validateFilters(doc, req.Filters); len(errs) > 0 {
+			c.JSON(http.StatusBadRequest, errJSONWithDetails(fmt.Errorf("invalid filters"), errs))
+			return
+		}
+
+		var (
+			agg       *aggregation
+			sortSpecs []composer.SortSpec
+		)
 
-			if strings.ToUpper(s[1]) == "ASC" {
-				d = sqlcomposer.ASC
+		if req.Aggregate != nil {
+			if len(req.Fields) > 0 || req.PageMode == pageModeCursor || req.Cursor != "" {
+				c.JSON(http.StatusBadRequest, errJSON(fmt.Errorf("aggregate can not be combined with fields or cursor pagination")))
+				return
 			}
 
-			*sorts = append(*sorts, sqlcomposer.Sort{
-				Name:      s[0],
-				Direction: d,
-			})
+			var aggErrs []*aggregateError
+			agg, aggErrs = newAggregation(doc, req.Aggregate, req.Sorts)
+			if len(aggErrs) > 0 {
+				c.JSON(http.StatusBadRequest, errJSONWithDetails(fmt.Errorf("invalid aggregate"), aggErrs))
+				return
+			}
+		} else {
+			var sortErrs []*sortError
+			sortSpecs, sortErrs = validateSorts(doc, req.Sorts)
+			if len(sortErrs) > 0 {
+				c.JSON(http.StatusBadRequest, errJSONWithDetails(fmt.Errorf("invalid sorts"), sortErrs))
+				return
+			}
 		}
+		sorts := doc.OrderBy(sortSpecs)
 
-		var doc sqlcomposer.SqlApiDoc
+		params, paramErrs := bindParams(doc, req.Params)
+		if len(paramErrs) > 0 {
+			c.JSON(http.StatusBadRequest, errJSONWithDetails(fmt.Errorf("invalid params"), paramErrs))
+			return
+		}
 
-		buffer := []byte(docFound.Content.String)
-		err = yaml.Unmarshal(buffer, &doc)
-		if err != nil {
-			log.Error(err)
-			c.JSON(http.StatusBadRequest, errJSON(err))
+		fields, fieldErrs := validateFields(doc, req.Fields)
+		if len(fieldErrs) > 0 {
+			c.JSON(http.StatusBadRequest, errJSONWithDetails(fmt.Errorf("invalid fields"), fieldErrs))
+			return
+		}
+
+		if (req.PageMode == pageModeCursor || req.Cursor != "") && responseFormat(c) != formatJSON {
+			c.JSON(http.StatusBadRequest, errJSON(fmt.Errorf("cursor pagination is only supported with json rows")))
+			return
+		}
+
+		if len(req.Facets) > 0 {
+			if agg != nil || responseFormat(c) != formatJSON {
+				c.JSON(http.StatusBadRequest, errJSON(fmt.Errorf("facets are only returned with json rows")))
+				return
+			}
+			if req.FacetLimit < 0 || req.FacetLimit > maxFacetLimit {
+				c.JSON(http.StatusBadRequest, errJSON(fmt.Errorf("facet_limit must be between 1 and %d", maxFacetLimit)))
+				return
+			}
+			if errs := validateFacets(doc, req.Facets); len(errs) > 0 {
+				c.JSON(http.StatusBadRequest, errJSONWithDetails(fmt.Errorf("invalid facets"), errs))
+				return
+			}
+		}
+
+		if timeout := queryTimeout(doc); timeout > 0 {
+			ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+			defer cancel()
+			c.Request = c.Request.WithContext(ctx)
 		}
 
 		dbc, err := models.DatabaseConfigs(qm.Where("name = ?", docFound.DBName)).One(c, db)
@@ -128,214 +233,494 @@ func SqlComposerHandler() gin.HandlerFunc {
 			return
 		}
 
-		db, err := sqlx.Connect("mysql", dbc.DSN.String)
+		src, err := sources.Get(dbc)
 		if err != nil {
 			log.WithField("dsn", dbc.DSN.String).Error(err)
 			c.JSON(http.StatusBadRequest, errJSON(err))
 			return
 		}
+		defer sources.Release(src)
+
+		switch req.RowFormat {
+		case "", rowFormatObject, rowFormatArray:
+		default:
+			c.JSON(http.StatusBadRequest, errJSON(fmt.Errorf("unsupported row_format %s", req.RowFormat)))
+			return
+		}
 
-		defer db.Close()
+		decodeOpts := &decodeOptions{
+			Loc:             src.Loc,
+			DecimalAsString: req.DecimalAsString || doc.Options.DecimalAsString,
+			RowFormat:       req.RowFormat,
+			Fields:          fields,
+		}
 
-		sqlBuilder, err := sqlcomposer.NewSqlBuilder(db, []byte(docFound.Content.String))
+		sqlBuilder, err := compiled.NewBuilder(src.DB)
 		if err != nil {
 			log.Error(err)
 			c.JSON(http.StatusBadRequest, errJSON(err))
 			return
 		}
 
-		configureSqlCompose(sqlBuilder)
+		if fields != nil {
+			sqlBuilder.Doc = doc.Project(selectFields(doc, fields, sortSpecs))
+		}
 
-		result := struct {
-			Total    int64             `json:"total,omitempty"`
-			Data     []interface{}     `json:"data,omitempty"`
-			SQL      map[string]string `json:"sql"`
-			ExecTime string            `json:"exec_time"`
-		}{}
+		params, err = configureSqlCompose(c.Request.Context(), sqlBuilder, src, params)
+		if err != nil {
+			log.Error(err)
+			writeConfigureError(c, err)
+			return
+		}
 
-		result.SQL = make(map[string]string)
+		result := &sqlComposerResult{SQL: make(map[string]string)}
 
-		err = sqlBuilder.AddFilters(custFilters, sqlcomposer.AND)
+		err = addFilters(sqlBuilder, req.Filters)
 		if err != nil {
 			log.Error(err)
+			c.JSON(http.StatusBadRequest, errJSON(err))
+			return
 		}
 
-		for key := range doc.Composition.Subject {
-			sqlBuilder.Limit((req.PageIndex-1)*req.PageLimit, req.PageLimit)
+		if agg != nil {
+			inner, args, err := rebindAll(doc, sqlBuilder, composer.AggregateSubject, params)
+			if err != nil {
+				log.Error(err)
+				c.JSON(http.StatusBadRequest, errJSON(err))
+				return
+			}
 
-			var (
-				q string
-				a []interface{}
-			)
-			{
-				if sorts.IsEmpty() {
-					q, a, err = sqlBuilder.Rebind(key)
-				} else {
-					q, a, err = sqlBuilder.OrderBy(sorts).Rebind(key)
+			offset, size := exportLimit(&req)
+			data, total := agg.queries(src.Dialect, inner, args, offset, size)
+			subjects := []*subjectQuery{data}
+
+			switch format := responseFormat(c); format {
+			case formatJSON:
+				var queries []*subjectQuery
+				for _, sq := range []*subjectQuery{total, data} {
+					if sq == nil {
+						continue
+					}
+					if debug == "1" {
+						result.SQL[sq.Key] = sq.SQL
+					}
+					queries = append(queries, sq)
 				}
+				writeJSON(c, src, queries, doc, decodeOpts, result, start)
+			case formatCSV:
+				writeCSV(c, src, data, exportFilename(path, formatCSV), nil)
+			case formatXLSX:
+				writeXLSX(c, src, &xlsxExport{Path: path, Subjects: subjects, Total: total, Filters: req.Filters, Start: start, Snapshot: doc.Options.Snapshot})
+			case formatNDJSON:
+				var sqls map[string]string
+				if debug == "1" {
+					sqls = map[string]string{data.Key: data.SQL}
+				}
+				writeNDJSON(c, src, subjects, total, doc.Options.Snapshot, sqls, decodeOpts, start)
+			default:
+				c.JSON(http.StatusBadRequest, errJSON(fmt.Errorf("unsupported format %s", format)))
 			}
+			return
+		}
 
-			if debug == "1" {
-				result.SQL[key] = q
+		switch format := responseFormat(c); format {
+		case formatJSON:
+		case formatCSV:
+			key, err := exportSubject(c, doc)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, errJSON(err))
+				return
 			}
 
+			q, a, err := rebind(doc, sqlBuilder.Limit(exportLimit(&req)).OrderBy(sorts), key, params)
 			if err != nil {
 				log.Error(err)
-				c.JSON(http.StatusBadRequest, err)
+				c.JSON(http.StatusBadRequest, errJSON(err))
 				return
 			}
 
-			if key == "total" {
-				var total int64
-				err = db.QueryRowx(q, a...).Scan(&total)
+			writeCSV(c, src, &subjectQuery{Key: key, SQL: q, Args: a}, exportFilename(path, formatCSV), fields)
+			return
+		case formatXLSX:
+			ex := &xlsxExport{
+				Path:     path,
+				Filters:  req.Filters,
+				Sorts:    sorts,
+				Start:    start,
+				Fields:   fields,
+				Snapshot: doc.Options.Snapshot,
+			}
+
+			sqlBuilder.Limit(exportLimit(&req)).OrderBy(sorts)
+			for _, key := range doc.SubjectKeys() {
+				q, a, err := rebind(doc, sqlBuilder, key, params)
 				if err != nil {
 					log.Error(err)
-					c.JSON(http.StatusBadRequest, errJSONWithSQL(err, q))
+					c.JSON(http.StatusBadRequest, errJSON(err))
 					return
 				}
-				result.Total = total
-			} else {
-				rows, err := db.Queryx(q, a...)
 
+				sq := &subjectQuery{Key: key, SQL: q, Args: a}
+				if key == "total" {
+					ex.Total = sq
+				} else {
+					ex.Subjects = append(ex.Subjects, sq)
+				}
+			}
+
+			writeXLSX(c, src, ex)
+			return
+		case formatNDJSON:
+			var (
+				subjects []*subjectQuery
+				total    *subjectQuery
+				sqls     map[string]string
+			)
+
+			if debug == "1" {
+				sqls = make(map[string]string)
+			}
+
+			sqlBuilder.Limit(exportLimit(&req)).OrderBy(sorts)
+			for _, key := range doc.SubjectKeys() {
+				q, a, err := rebind(doc, sqlBuilder, key, params)
 				if err != nil {
 					log.Error(err)
-					c.JSON(http.StatusBadRequest, errJSONWithSQL(err, q))
+					c.JSON(http.StatusBadRequest, errJSON(err))
 					return
 				}
 
-				for rows.Next() {
-					item := make(map[string]interface{})
-					err := rows.MapScan(item)
-					if err != nil {
-						log.Error(err)
-					}
+				if sqls != nil {
+					sqls[key] = q
+				}
 
-					for k, encoded := range item {
-						switch encoded.(type) {
-						case []byte:
-							item[k] = string(encoded.([]byte))
-						}
-					}
+				sq := &subjectQuery{Key: key, SQL: q, Args: a}
+				if key == "total" {
+					total = sq
+				} else {
+					subjects = append(subjects, sq)
+				}
+			}
+
+			writeNDJSON(c, src, subjects, total, doc.Options.Snapshot, sqls, decodeOpts, start)
+			return
+		default:
+			c.JSON(http.StatusBadRequest, errJSON(fmt.Errorf("unsupported format %s", format)))
+			return
+		}
+
+		var ks *keyset
+		switch req.PageMode {
+		case "", pageModeOffset, pageModeCursor:
+		default:
+			c.JSON(http.StatusBadRequest, errJSON(fmt.Errorf("unsupported page_mode %s", req.PageMode)))
+			return
+		}
+
+		if req.PageMode == pageModeCursor || req.Cursor != "" {
+			ks, err = newKeyset(doc, sortSpecs, req.PageLimit)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, errJSON(err))
+				return
+			}
+			sorts = &ks.sorts
+		}
+
+		if ks != nil {
+			// one extra row tells whether there is a next page
+			sqlBuilder.Limit(0, ks.size+1)
+		} else {
+			sqlBuilder.Limit((req.PageIndex-1)*req.PageLimit, req.PageLimit)
+		}
+
+		if !sorts.IsEmpty() {
+			sqlBuilder.OrderBy(sorts)
+		}
+
+		var queries []*subjectQuery
+		build := func(key string) bool {
+			q, a, err := rebind(doc, sqlBuilder, key, params)
+
+			if debug == "1" {
+				result.SQL[key] = q
+			}
 
-					result.Data = append(result.Data, item)
+			if err != nil {
+				log.Error(err)
+				c.JSON(http.StatusBadRequest, errJSON(err))
+				return false
+			}
+
+			queries = append(queries, &subjectQuery{Key: key, SQL: q, Args: a})
+			return true
+		}
+
+		// the total is counted before the seek predicate narrows the conditions
+		if _, ok := doc.Composition.Subject["total"]; ok {
+			if !build("total") {
+				return
+			}
+		}
+
+		if ks != nil && req.Cursor != "" {
+			vals, err := ks.decode(req.Cursor)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, errJSON(err))
+				return
+			}
+			// SQL Server has no row value comparison
+			rowValues := src.Dialect.Driver() != datasource.DriverSQLServer
+			sqlBuilder.AndConditions(ks.predicate(vals, sqlBuilder.Conditions.Arg, rowValues))
+		}
+
+		for _, key := range doc.SubjectKeys() {
+			if key != "total" && !build(key) {
+				return
+			}
+		}
+
+		// the first subject after the total carries the cursor
+		if ks != nil {
+			for _, sq := range queries {
+				if sq.Key != "total" {
+					sq.Keyset = ks
+					break
 				}
 			}
 		}
 
-		result.ExecTime = time.Since(start).String()
+		facets, err := facetQueries(c.Request.Context(), compiled, src, &req, params)
+		if err != nil {
+			log.Error(err)
+			writeConfigureError(c, err)
+			return
+		}
+		for _, sq := range facets {
+			if debug == "1" {
+				result.SQL[sq.Key] = sq.SQL
+			}
+			queries = append(queries, sq)
+		}
 
-		c.JSON(http.StatusOK, result)
+		writeJSON(c, src, queries, doc, decodeOpts, result, start)
 	}
 }
 
-type attrsTokenReplacer struct {
-	Attrs map[string]string
-	DB    *sqlx.DB
+// sqlComposerResult is the json response of SqlComposerHandler
+type sqlComposerResult struct {
+	Total      int64                    `json:"total,omitempty"`
+	Columns    map[string][]columnInfo  `json:"columns,omitempty"`
+	Data       []interface{}            `json:"data,omitempty"`
+	NextCursor string                   `json:"next_cursor,omitempty"`
+	Facets     map[string][]interface{} `json:"facets,omitempty"`
+	SQL        map[string]string        `json:"sql"`
+	ExecTime   string                   `json:"exec_time"`
 }
 
-func (atr *attrsTokenReplacer) TokenReplace(ctx map[string]interface{}) string {
-	return ProductAttrsToJoinInStat(atr.DB, atr.Attrs)
-}
+// writeJSON runs the queries and answers with their rows collected in result
+func writeJSON(c *gin.Context, src *datasource.Source, queries []*subjectQuery, doc *composer.Doc, opts *decodeOptions, result *sqlComposerResult, start time.Time) {
+	results, err := runSubjects(c.Request.Context(), src, queries, doc.Concurrency(), doc.Options.Snapshot, opts)
+	if err != nil {
+		log.Error(err)
+		if se, ok := err.(*subjectError); ok {
+			c.JSON(queryErrorStatus(c.Request.Context()), errJSONWithSQL(se.Err, se.SQL))
+		} else {
+			c.JSON(queryErrorStatus(c.Request.Context()), errJSON(err))
+		}
+		return
+	}
 
-type attrsFieldsTokenReplacer struct {
-	Attrs map[string]string
-}
+	for i, sq := range queries {
+		if sq.Key == "total" {
+			result.Total = results[i].Total
+			continue
+		}
 
-func (atr *attrsFieldsTokenReplacer) TokenReplace(ctx map[string]interface{}) string {
-	return ProductAttrsToSelect(atr.Attrs)
-}
+		if sq.Facet != "" {
+			if result.Facets == nil {
+				result.Facets = make(map[string][]interface{})
+			}
+			result.Facets[sq.Facet] = append([]interface{}{}, results[i].Rows...)
+			continue
+		}
+
+		if result.Columns == nil {
+			result.Columns = make(map[string][]columnInfo)
+		}
+		result.Columns[sq.Key] = results[i].Columns
 
-var once sync.Once
+		rows := results[i].Rows
+		if sq.Keyset != nil && int64(len(rows)) > sq.Keyset.size {
+			rows = rows[:sq.Keyset.size]
+			result.NextCursor = results[i].NextCursor
+		}
 
-type DictTypesSingleton map[string]string
+		result.Data = append(result.Data, rows...)
+	}
 
-var dictTypes DictTypesSingleton
+	result.ExecTime = time.Since(start).String()
 
-type DictType struct {
-	SID         string         `db:"sid"`
-	Code        string         `db:"code"`
-	Name        string         `db:"name"`
-	Status      int            `db:"status"`
-	Description string         `db:"description"`
-	CreateTime  sql.NullString `db:"create_time"`
-	UpdateTime  sql.NullString `db:"update_time"`
-	IsDelete    int            `db:"is_delete"`
-	ParentCode  sql.NullString `db:"parent_code"`
+	c.JSON(http.StatusOK, result)
 }
 
-func GetMESDictTypes(db *sqlx.DB) DictTypesSingleton {
-	once.Do(func() {
-		dictTypes = DictTypesSingleton{}
+// attrParam is a param of the attrs tokens: a dictionary code, the alias of
+// its fty_obj_attr join and the column its value is selected as. SID is the
+// dictionary id of the code and SIDArg the placeholder bound to it, both only
+// set for the joins.
+type attrParam struct {
+	Code   string
+	Alias  string
+	Column string
+	SID    string
+	SIDArg string
+}
 
-		var types []DictType
-		types = []DictType{}
-		err := db.Select(&types, "SELECT * FROM fty_dictionary_type")
+// parseAttrParams validates the params of an attrs token for d, the values
+// are the column names when withColumn is set.
+func parseAttrParams(d datasource.Dialect, params []sqlcomposer.TokenParam, withColumn bool) ([]attrParam, error) {
+	attrs := make([]attrParam, 0, len(params))
+	aliases := make(map[string]string, len(params))
 
+	for _, p := range params {
+		alias, err := datasource.SanitizeAlias(d, p.Name)
 		if err != nil {
-			log.Error(err)
+			return nil, &tokenError{Param: p.Name, Err: err}
+		}
+		if code, ok := aliases[alias]; ok {
+			return nil, &tokenError{
+				Param: p.Name,
+				Err:   fmt.Errorf("attributes %s and %s have the same alias %s", code, p.Name, alias),
+			}
 		}
+		aliases[alias] = p.Name
 
-		for _, value := range types {
-			dictTypes[value.Code] = value.SID
+		var column string
+		if withColumn {
+			if err := datasource.ValidIdent(p.Value); err != nil {
+				return nil, &tokenError{Param: p.Name, Err: fmt.Errorf("column of attribute %s: %v", p.Name, err)}
+			}
+			column = d.FoldIdent(p.Value)
 		}
-	})
 
-	return dictTypes
+		attrs = append(attrs, attrParam{Code: p.Name, Alias: alias, Column: column})
+	}
+
+	return attrs, nil
 }
 
-func ProductAttrsToJoinInStat(db *sqlx.DB, a map[string]string) string {
-	var str []string
+// resolveAttrs sets the dictionary ids of attrs from the dictionary of src,
+// it fails on the first code missing from it.
+func resolveAttrs(ctx context.Context, src *datasource.Source, attrs []attrParam) error {
+	dt, err := dictionaries.Types(ctx, src)
+	if err != nil {
+		return err
+	}
 
-	dt := GetMESDictTypes(db)
+	for i, a := range attrs {
+		sid, ok := dt[a.Code]
+		if !ok {
+			return &tokenError{Param: a.Code, Err: fmt.Errorf("unknown attribute %s", a.Code)}
+		}
+		attrs[i].SID = sid
+	}
 
-	for key, _ := range a {
-		alias := strings.Replace(key, "-", "_", -1)
+	return nil
+}
 
-		sid, ok := dt[key]
+type attrsTokenReplacer struct {
+	Attrs   []attrParam
+	Dialect datasource.Dialect
+}
 
-		if !ok {
-			os.Exit(500)
-		}
+func (atr *attrsTokenReplacer) TokenReplace(ctx map[string]interface{}) string {
+	return ProductAttrsToJoinInStat(atr.Dialect, atr.Attrs)
+}
+
+type attrsFieldsTokenReplacer struct {
+	Attrs   []attrParam
+	Dialect datasource.Dialect
+}
+
+func (atr *attrsFieldsTokenReplacer) TokenReplace(ctx map[string]interface{}) string {
+	return ProductAttrsToSelect(atr.Dialect, atr.Attrs)
+}
+
+// ProductAttrsToJoinInStat joins the attributes of fty_product, the dictionary
+// ids of attrs must be resolved and bound.
+func ProductAttrsToJoinInStat(d datasource.Dialect, attrs []attrParam) string {
+	var str []string
 
+	for _, a := range attrs {
+		alias := d.QuoteIdent(a.Alias)
 		str = append(str,
-			fmt.Sprintf(`LEFT JOIN fty_obj_attr AS %s ON %s.attr_sid = '%s' AND %s.obj_sid = fty_product.sid`,
-				alias, alias, sid, alias))
+			fmt.Sprintf(`LEFT JOIN fty_obj_attr AS %s ON %s.attr_sid = %s AND %s.obj_sid = fty_product.sid`,
+				alias, alias, a.SIDArg, alias))
 	}
 	sort.Strings(str)
 	return strings.Join(str, " ")
 }
 
-func ProductAttrsToSelect(a map[string]string) string {
+func ProductAttrsToSelect(d datasource.Dialect, attrs []attrParam) string {
 	var str []string
-	for key, value := range a {
-		alias := strings.Replace(key, "-", "_", -1)
-		str = append(str, fmt.Sprintf("%s.attr_value AS %s", alias, value))
+	for _, a := range attrs {
+		str = append(str, fmt.Sprintf("%s.attr_value AS %s", d.QuoteIdent(a.Alias), d.QuoteIdent(a.Column)))
 	}
 	sort.Strings(str)
 	return strings.Join(str, ",")
 }
 
-func configureSqlCompose(sb *sqlcomposer.SqlBuilder) {
-	sb.RegisterToken("attrs", func(params []sqlcomposer.TokenParam) sqlcomposer.TokenReplacer {
-		attrs := map[string]string{}
-		for _, p := range params {
-			attrs[p.Name] = p.Value
+// configureSqlCompose registers the tokens and pipelines of the service and
+// the stored tokens of src. It returns params along with the values the tokens
+// bind, the params to build the statements of sb with. It fails with a
+// *tokenError on token params that are not safe to put in a statement or that
+// name unknown attributes or values.
+func configureSqlCompose(ctx context.Context, sb *sqlcomposer.SqlBuilder, src *datasource.Source, params map[string]interface{}) (map[string]interface{}, error) {
+	tokens := &tokenRegistry{sb: sb}
+
+	tokens.register("attrs", func(params []sqlcomposer.TokenParam) (sqlcomposer.TokenReplacer, error) {
+		attrs, err := parseAttrParams(src.Dialect, params, false)
+		if err != nil {
+			return nil, err
 		}
-		return &attrsTokenReplacer{
-			Attrs: attrs,
-			DB:    sb.DB,
+		if err := resolveAttrs(ctx, src, attrs); err != nil {
+			return nil, err
+		}
+		for i := range attrs {
+			attrs[i].SIDArg = tokens.bind(attrs[i].SID)
 		}
+		return &attrsTokenReplacer{
+			Attrs:   attrs,
+			Dialect: src.Dialect,
+		}, nil
 	})
 
-	sb.RegisterToken("attrs_fields", func(params []sqlcomposer.TokenParam) sqlcomposer.TokenReplacer {
-		attrs := map[string]string{}
-		for _, p := range params {
-			attrs[p.Name] = p.Value
+	tokens.register("attrs_fields", func(params []sqlcomposer.TokenParam) (sqlcomposer.TokenReplacer, error) {
+		attrs, err := parseAttrParams(src.Dialect, params, true)
+		if err != nil {
+			return nil, err
 		}
 		return &attrsFieldsTokenReplacer{
-			Attrs: attrs,
-		}
+			Attrs:   attrs,
+			Dialect: src.Dialect,
+		}, nil
 	})
 
+	if err := tokens.registerStoredTokens(ctx, src); err != nil {
+		return nil, err
+	}
+
 	_ = sb.RegisterPipelineType("fulltext")
+
+	if tokens.err != nil {
+		return nil, tokens.err
+	}
+
+	all := make(map[string]interface{}, len(params)+len(tokens.args))
+	for k, v := range params {
+		all[k] = v
+	}
+	for k, v := range tokens.args {
+		all[k] = v
+	}
+	return all, nil
 }
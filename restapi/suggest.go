@@ -0,0 +1,290 @@
+package restapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/user/sqlcomposer-svc/composer"
+	"github.com/user/sqlcomposer-svc/models"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+	"github.com/wangxb07/sqlcomposer"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// suggestSuffix turns a doc path into its suggest endpoint
+	suggestSuffix = "/suggest"
+
+	maxSuggestLimit  = 100
+	maxSuggestPrefix = 100
+
+	suggestCacheTTL  = 30 * time.Second
+	suggestCacheSize = 1000
+)
+
+type SuggestRequest struct {
+	Field   string                   `json:"field"`
+	Prefix  string                   `json:"prefix"`
+	Limit   int                      `json:"limit"`
+	Filters []*SqlComposerFilterItem `json:"filters"`
+	Params  map[string]interface{}   `json:"params"`
+}
+
+type suggestResult struct {
+	Field    string        `json:"field"`
+	Values   []interface{} `json:"values"`
+	Cached   bool          `json:"cached"`
+	SQL      string        `json:"sql,omitempty"`
+	ExecTime string        `json:"exec_time"`
+}
+
+// suggestCache keeps suggestions for a short time, typeahead requests the
+// same prefixes over and over. Entries are keyed by the version of the doc and
+// of the datasource they were read from, the entries of a datasource are
+// dropped when its pool is evicted.
+type suggestCache struct {
+	mu      sync.Mutex
+	entries map[string]suggestEntry
+}
+
+type suggestEntry struct {
+	dbName  string
+	values  []interface{}
+	expires time.Time
+}
+
+var suggestions *suggestCache
+
+func newSuggestCache() *suggestCache {
+	return &suggestCache{entries: make(map[string]suggestEntry)}
+}
+
+func (sc *suggestCache) get(key string) ([]interface{}, bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	e, ok := sc.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		return nil, false
+	}
+	return e.values, true
+}
+
+func (sc *suggestCache) put(dbName, key string, values []interface{}) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	now := time.Now()
+	if len(sc.entries) >= suggestCacheSize {
+		for k, e := range sc.entries {
+			if now.After(e.expires) {
+				delete(sc.entries, k)
+			}
+		}
+		if len(sc.entries) >= suggestCacheSize {
+			sc.entries = make(map[string]suggestEntry)
+		}
+	}
+
+	sc.entries[key] = suggestEntry{dbName: dbName, values: values, expires: now.Add(suggestCacheTTL)}
+}
+
+// invalidate drops the suggestions read from the named datasource
+func (sc *suggestCache) invalidate(dbName string) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	for k, e := range sc.entries {
+		if e.dbName == dbName {
+			delete(sc.entries, k)
+		}
+	}
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern with !
+func escapeLike(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+}
+
+// @Summary 获取字段联想值
+// @Tags 接口
+// @version 1.0
+// @Param path path string true "doc path followed by /suggest"
+// @Param debug query string false "debug"
+// @Success 200 {string} string	"json"
+// @Failure 400 {object} Error "error"
+// @Failure 404 {object} Error "not found"
+// @Failure 504 {object} Error "query timeout"
+// @Router /{path}/suggest [post]
+func SuggestHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		path := strings.TrimSuffix(c.Param("path"), suggestSuffix)
+		debug := c.Query("debug")
+
+		docFound, err := models.Docs(qm.Where("path = ?", path)).One(c, db)
+		if err != nil {
+			log.Error(err)
+			c.JSON(http.StatusNotFound, errJSON(err))
+			return
+		}
+
+		var req SuggestRequest
+		if err := c.BindJSON(&req); err != nil {
+			log.Error(err)
+			c.JSON(http.StatusBadRequest, errJSON(err))
+			return
+		}
+
+		compiled, err := docs.Get(docFound)
+		if err != nil {
+			log.Error(err)
+			c.JSON(http.StatusBadRequest, errJSON(err))
+			return
+		}
+
+		doc := compiled.Doc
+
+		spec, ok := doc.SuggestSpec(req.Field)
+		if !ok {
+			c.JSON(http.StatusBadRequest, errJSON(fmt.Errorf("field %s is not suggestible", req.Field)))
+			return
+		}
+
+		if len(req.Prefix) > maxSuggestPrefix {
+			c.JSON(http.StatusBadRequest, errJSON(fmt.Errorf("prefix is longer than %d", maxSuggestPrefix)))
+			return
+		}
+
+		limit := spec.Limit
+		if req.Limit != 0 {
+			limit = req.Limit
+		}
+		if limit < 1 || limit > maxSuggestLimit {
+			c.JSON(http.StatusBadRequest, errJSON(fmt.Errorf("limit must be between 1 and %d", maxSuggestLimit)))
+			return
+		}
+
+		// filters on the suggested attribute would only suggest the current value
+		filters := withoutAttr(req.Filters, spec.Attr)
+
+		if errs := validateFilters(doc, filters); len(errs) > 0 {
+			c.JSON(http.StatusBadRequest, errJSONWithDetails(fmt.Errorf("invalid filters"), errs))
+			return
+		}
+
+		params, paramErrs := bindParams(doc, req.Params)
+		if len(paramErrs) > 0 {
+			c.JSON(http.StatusBadRequest, errJSONWithDetails(fmt.Errorf("invalid params"), paramErrs))
+			return
+		}
+
+		dbc, err := models.DatabaseConfigs(qm.Where("name = ?", docFound.DBName)).One(c, db)
+		if err != nil {
+			log.Error(err)
+			c.JSON(http.StatusBadRequest, errJSON(err))
+			return
+		}
+
+		cacheKey, _ := json.Marshal([]interface{}{
+			path, compiled.UpdatedAt, dbc.Name.String, dbc.UpdatedAt.Time,
+			req.Field, req.Prefix, limit, filters, req.Params,
+		})
+		if values, ok := suggestions.get(string(cacheKey)); ok {
+			c.JSON(http.StatusOK, &suggestResult{
+				Field:    req.Field,
+				Values:   values,
+				Cached:   true,
+				ExecTime: time.Since(start).String(),
+			})
+			return
+		}
+
+		if timeout := queryTimeout(doc); timeout > 0 {
+			ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+			defer cancel()
+			c.Request = c.Request.WithContext(ctx)
+		}
+
+		src, err := sources.Get(dbc)
+		if err != nil {
+			log.WithField("dsn", dbc.DSN.String).Error(err)
+			c.JSON(http.StatusBadRequest, errJSON(err))
+			return
+		}
+		defer sources.Release(src)
+
+		sb, err := compiled.NewBuilder(src.DB)
+		if err != nil {
+			log.Error(err)
+			c.JSON(http.StatusBadRequest, errJSON(err))
+			return
+		}
+
+		params, err = configureSqlCompose(c.Request.Context(), sb, src, params)
+		if err != nil {
+			log.Error(err)
+			writeConfigureError(c, err)
+			return
+		}
+
+		if err := addFilters(sb, filters); err != nil {
+			log.Error(err)
+			c.JSON(http.StatusBadRequest, errJSON(err))
+			return
+		}
+
+		if req.Prefix != "" {
+			// a parameter name no filter can produce, filter names have no dot
+			sb.AndConditions(&sqlcomposer.ConditionStmt{
+				Clause:      fmt.Sprintf("%s LIKE :suggest.prefix ESCAPE '!'", spec.Attr),
+				Arg:         map[string]interface{}{"suggest.prefix": escapeLike(req.Prefix) + "%"},
+				ClauseSlice: map[string]string{},
+			})
+		}
+
+		inner, args, err := rebindAll(doc, sb, composer.AggregateSubject, params)
+		if err != nil {
+			log.Error(err)
+			c.JSON(http.StatusBadRequest, errJSON(err))
+			return
+		}
+
+		sq := &subjectQuery{
+			Key: "suggest",
+			SQL: fmt.Sprintf("SELECT DISTINCT %s FROM (%s) AS suggest WHERE %s IS NOT NULL ORDER BY %s %s",
+				spec.Field, inner, spec.Field, spec.Field, src.Dialect.Limit(0, int64(limit), true)),
+			Args: args,
+		}
+
+		res, err := runSubjectIn(c.Request.Context(), src, nil, sq, &decodeOptions{Loc: src.Loc, RowFormat: rowFormatArray})
+		if err != nil {
+			log.Error(err)
+			c.JSON(queryErrorStatus(c.Request.Context()), errJSONWithSQL(err, sq.SQL))
+			return
+		}
+
+		values := make([]interface{}, 0, len(res.Rows))
+		for _, row := range res.Rows {
+			values = append(values, row.([]interface{})[0])
+		}
+
+		suggestions.put(dbc.Name.String, string(cacheKey), values)
+
+		result := &suggestResult{
+			Field:    req.Field,
+			Values:   values,
+			ExecTime: time.Since(start).String(),
+		}
+		if debug == "1" {
+			result.SQL = sq.SQL
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
@@ -0,0 +1,60 @@
+package restapi
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+const suggestDoc = `
+info:
+  name: orders
+  version: 1.0.0
+composition:
+  subject:
+    data: "SELECT id, status FROM orders %where %limit"
+suggest:
+  - field: status
+`
+
+func TestSuggest(t *testing.T) {
+	s := newTestService(t)
+	defer s.Close()
+	s.addDoc("/orders", suggestDoc)
+
+	var data struct {
+		Data []map[string]interface{} `json:"data"`
+	}
+	if code := s.post("/sql-composer/orders", map[string]interface{}{"page_index": 1, "page_limit": 10}, &data); code != http.StatusOK || len(data.Data) != 3 {
+		t.Fatalf("doc query: %d %+v", code, data)
+	}
+
+	var res suggestResult
+	suggest := func(prefix string) {
+		res = suggestResult{}
+		if code := s.post("/sql-composer/orders/suggest", map[string]interface{}{"field": "status", "prefix": prefix}, &res); code != http.StatusOK {
+			t.Fatalf("suggest %q: got %d", prefix, code)
+		}
+	}
+
+	suggest("")
+	if !reflect.DeepEqual(res.Values, []interface{}{"A", "B"}) || res.Cached {
+		t.Errorf("got %+v", res)
+	}
+
+	suggest("")
+	if !res.Cached {
+		t.Errorf("second suggest is not cached")
+	}
+
+	sources.Evict("lite")
+	suggest("")
+	if res.Cached {
+		t.Errorf("suggest is cached after the datasource was evicted")
+	}
+
+	suggest("B")
+	if !reflect.DeepEqual(res.Values, []interface{}{"B"}) {
+		t.Errorf("prefix B: got %+v", res.Values)
+	}
+}
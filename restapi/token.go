@@ -0,0 +1,234 @@
+package restapi
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/user/sqlcomposer-svc/composer"
+	"github.com/user/sqlcomposer-svc/datasource"
+	"github.com/user/sqlcomposer-svc/models"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+	"github.com/wangxb07/sqlcomposer"
+	"net/http"
+	"strconv"
+)
+
+// tokenError is a token of a doc that can not be replaced, e.g. one whose
+// params name an unknown attribute. Param is empty when no param is to blame.
+type tokenError struct {
+	Token string
+	Param string
+	Err   error
+}
+
+func (e *tokenError) Error() string {
+	return fmt.Sprintf("token %s: %v", e.Token, e.Err)
+}
+
+// tokenGen builds the replacer of a token from its params, it fails on params
+// it can not replace.
+type tokenGen func(params []sqlcomposer.TokenParam) (sqlcomposer.TokenReplacer, error)
+
+// failedToken stands in for a token whose generator failed, the builder it is
+// registered on must not be used.
+type failedToken struct{}
+
+func (failedToken) TokenReplace(ctx map[string]interface{}) string { return "" }
+
+// tokenRegistry registers the tokens of one builder and keeps the first
+// failure of their generators and the values their replacements bind.
+type tokenRegistry struct {
+	sb   *sqlcomposer.SqlBuilder
+	err  error
+	args map[string]interface{}
+}
+
+// bind returns a named placeholder bound to v. The names have a dot, which
+// the names of doc params and filters never have.
+func (r *tokenRegistry) bind(v interface{}) string {
+	if r.args == nil {
+		r.args = make(map[string]interface{})
+	}
+	name := fmt.Sprintf("tokens.%d", len(r.args)+1)
+	r.args[name] = v
+	return ":" + name
+}
+
+// register registers gen for name, it only runs when the doc uses the token.
+func (r *tokenRegistry) register(name string, gen tokenGen) {
+	r.sb.RegisterToken(name, func(params []sqlcomposer.TokenParam) sqlcomposer.TokenReplacer {
+		tr, err := gen(params)
+		if err == nil {
+			return tr
+		}
+
+		if r.err == nil {
+			te, ok := err.(*tokenError)
+			if !ok {
+				te = &tokenError{Err: err}
+			}
+			te.Token = name
+			r.err = te
+		}
+		return failedToken{}
+	})
+}
+
+// renderedToken is the text a stored token renders to for the params of a doc.
+// It is not a plain string, sqlcomposer takes tokens of kind string as is.
+type renderedToken struct {
+	text string
+}
+
+func (t *renderedToken) TokenReplace(ctx map[string]interface{}) string { return t.text }
+
+// registerStoredTokens registers the tokens of the token table of src that the
+// doc of the registry uses.
+func (r *tokenRegistry) registerStoredTokens(ctx context.Context, src *datasource.Source) error {
+	var names []interface{}
+	for name := range r.sb.Doc.Composition.Tokens {
+		if !composer.IsReservedToken(name) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	rows, err := models.Tokens(qm.Where("db_name = ?", src.Name), qm.WhereIn("name IN ?", names...)).All(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		t, err := storedTokens.Get(row)
+		if err != nil {
+			return &tokenError{Token: row.Name, Err: err}
+		}
+
+		r.register(t.Name, func(params []sqlcomposer.TokenParam) (sqlcomposer.TokenReplacer, error) {
+			s, err := renderToken(ctx, src, t, params, r.bind)
+			if err != nil {
+				return nil, err
+			}
+			return &renderedToken{text: s}, nil
+		})
+	}
+
+	return nil
+}
+
+// renderToken renders t for the params of a doc, every value is looked up
+// when its param says so and put in as SQL of the dialect of src, strings are
+// bound with bind.
+func renderToken(ctx context.Context, src *datasource.Source, t *composer.Token, params []sqlcomposer.TokenParam, bind func(interface{}) string) (string, error) {
+	values, err := t.Bind(params)
+	if err != nil {
+		return "", err
+	}
+
+	sqls := make(map[string]string, len(values))
+	for i := range t.Params {
+		ps := &t.Params[i]
+		name := ps.Name
+
+		v, ok := values[name]
+		if !ok {
+			continue
+		}
+
+		if ps.Lookup != "" {
+			if v, err = lookupTokenValue(ctx, src, ps, v); err != nil {
+				return "", err
+			}
+		}
+
+		q, err := tokenValueSQL(src.Dialect, ps.Type, v, bind)
+		if err != nil {
+			return "", &tokenError{Param: name, Err: fmt.Errorf("param %s: %v", name, err)}
+		}
+		sqls[name] = q
+	}
+
+	return t.Render(sqls)
+}
+
+// lookupTokenValue replaces v by the first column of the row the lookup of ps
+// reads for it, in a read only transaction.
+func lookupTokenValue(ctx context.Context, src *datasource.Source, ps *composer.TokenParamSpec, v interface{}) (interface{}, error) {
+	sess, err := openSession(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	defer sess.Close()
+
+	var res sql.NullString
+	err = sess.GetContext(ctx, &res, src.DB.Rebind(ps.Lookup), v)
+	if err == sql.ErrNoRows || (err == nil && !res.Valid) {
+		return nil, &tokenError{Param: ps.Name, Err: fmt.Errorf("unknown value %v of param %s", v, ps.Name)}
+	}
+	if err != nil {
+		return nil, &tokenError{Param: ps.Name, Err: fmt.Errorf("param %s: lookup: %v", ps.Name, err)}
+	}
+
+	return res.String, nil
+}
+
+// tokenValueSQL renders v as an identifier or a number, or as a placeholder
+// bound with bind to the string or bool v.
+func tokenValueSQL(d datasource.Dialect, typ string, v interface{}, bind func(interface{}) string) (string, error) {
+	switch typ {
+	case composer.TypeIdent:
+		return datasource.QuoteIdent(d, fmt.Sprint(v))
+	case composer.TypeInt:
+		switch n := v.(type) {
+		case int:
+			return strconv.Itoa(n), nil
+		case int64:
+			return strconv.FormatInt(n, 10), nil
+		case uint64:
+			return strconv.FormatUint(n, 10), nil
+		}
+		n, err := strconv.ParseInt(fmt.Sprint(v), 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("value %v is not a %s", v, typ)
+		}
+		return strconv.FormatInt(n, 10), nil
+	case composer.TypeNumber:
+		n, ok := composer.Number(v)
+		if !ok {
+			f, err := strconv.ParseFloat(fmt.Sprint(v), 64)
+			if err != nil {
+				return "", fmt.Errorf("value %v is not a %s", v, typ)
+			}
+			n = f
+		}
+		return strconv.FormatFloat(n, 'f', -1, 64), nil
+	case composer.TypeBool:
+		b, ok := v.(bool)
+		if !ok {
+			pb, err := strconv.ParseBool(fmt.Sprint(v))
+			if err != nil {
+				return "", fmt.Errorf("value %v is not a %s", v, typ)
+			}
+			b = pb
+		}
+		// SQL Server has no boolean literals
+		return bind(b), nil
+	}
+	return bind(fmt.Sprint(v)), nil
+}
+
+// writeConfigureError answers a failure of configureSqlCompose, a token error
+// is the doc not matching the data and is reported as 422.
+func writeConfigureError(c *gin.Context, err error) {
+	if te, ok := err.(*tokenError); ok {
+		c.JSON(http.StatusUnprocessableEntity, errJSONWithDetails(te, map[string]string{
+			"token": te.Token,
+			"param": te.Param,
+		}))
+		return
+	}
+	c.JSON(http.StatusBadRequest, errJSON(err))
+}
@@ -0,0 +1,196 @@
+package restapi
+
+import (
+	"fmt"
+	"github.com/user/sqlcomposer-svc/composer"
+	"github.com/user/sqlcomposer-svc/datasource"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestTokenValueSQL(t *testing.T) {
+	tests := []struct {
+		driver string
+		typ    string
+		v      interface{}
+		want   string
+		arg    interface{}
+	}{
+		{datasource.DriverMySQL, composer.TypeIdent, "amount", "`amount`", nil},
+		{datasource.DriverPostgres, composer.TypeIdent, "orders.Amount", `"orders"."amount"`, nil},
+		{datasource.DriverSQLite, composer.TypeIdent, "amount", `"amount"`, nil},
+		{datasource.DriverSQLServer, composer.TypeIdent, "amount", "[amount]", nil},
+		{datasource.DriverMySQL, composer.TypeNumber, 10.5, "10.5", nil},
+		{datasource.DriverSQLServer, composer.TypeInt, "3", "3", nil},
+		{datasource.DriverMySQL, composer.TypeInt, int64(9007199254740993), "9007199254740993", nil},
+		{datasource.DriverMySQL, composer.TypeInt, 7, "7", nil},
+		{datasource.DriverSQLServer, composer.TypeBool, true, ":arg", true},
+		{datasource.DriverMySQL, composer.TypeString, "2020-01-01 12:30:00", ":arg", "2020-01-01 12:30:00"},
+		{datasource.DriverPostgres, "", `x' OR 1=1 --`, ":arg", `x' OR 1=1 --`},
+	}
+
+	for _, tt := range tests {
+		d, err := datasource.DialectOf(tt.driver)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var arg interface{}
+		got, err := tokenValueSQL(d, tt.typ, tt.v, func(v interface{}) string {
+			arg = v
+			return ":arg"
+		})
+		if err != nil || got != tt.want || !reflect.DeepEqual(arg, tt.arg) {
+			t.Errorf("%s: tokenValueSQL(%s, %v) = %q, %v bound to %v, want %q bound to %v",
+				tt.driver, tt.typ, tt.v, got, err, arg, tt.want, tt.arg)
+		}
+	}
+
+	d, _ := datasource.DialectOf(datasource.DriverMySQL)
+	bind := func(v interface{}) string { return ":arg" }
+	for _, tt := range []struct {
+		typ string
+		v   interface{}
+	}{
+		{composer.TypeIdent, "a`b"},
+		{composer.TypeNumber, "1;DROP"},
+		{composer.TypeInt, "1.5"},
+		{composer.TypeInt, "9007199254740993;DROP"},
+		{composer.TypeBool, "maybe"},
+	} {
+		if got, err := tokenValueSQL(d, tt.typ, tt.v, bind); err == nil {
+			t.Errorf("tokenValueSQL(%s, %v) = %q, want an error", tt.typ, tt.v, got)
+		}
+	}
+}
+
+const tokenDoc = `
+info:
+  name: orders
+  version: 1.0.0
+composition:
+  tokens:
+    status_is:
+      params:
+        - name: status
+          value: B
+        - name: col
+          value: amount
+  subject:
+    data: "SELECT id FROM orders WHERE %status_is ORDER BY id"
+`
+
+func TestStoredToken(t *testing.T) {
+	s := newTestService(t)
+	defer s.Close()
+	s.addDoc("/token", tokenDoc)
+	s.exec("INSERT INTO token (uuid, name, db_name, template, params) VALUES (?, ?, ?, ?, ?)",
+		"t1", "status_is", "lite",
+		"status = {{.status}} AND {{.col}} > 0 AND '12:30' <> {{.status}}",
+		"- name: status\n  type: string\n  required: true\n- name: col\n  type: ident\n")
+
+	var res struct {
+		Data []struct {
+			ID int `json:"id"`
+		} `json:"data"`
+		Err string `json:"err"`
+	}
+	code := s.post("/sql-composer/token", map[string]interface{}{"page_index": 1, "page_limit": 10}, &res)
+	if code != http.StatusOK || len(res.Data) != 2 || res.Data[0].ID != 2 || res.Data[1].ID != 3 {
+		t.Errorf("got %d %+v", code, res)
+	}
+}
+
+// tokenErrorDoc is a doc of the lite datasource whose subject uses tokens
+func tokenErrorDoc(tokens, subject string) string {
+	return `
+info:
+  name: orders
+  version: 1.0.0
+composition:
+  tokens:
+` + tokens + `
+  subject:
+    data: "` + subject + `"
+`
+}
+
+func TestTokenErrors(t *testing.T) {
+	s := newTestService(t)
+	defer s.Close()
+
+	src := s.lite()
+	_, err := src.DB.Exec(`CREATE TABLE fty_dictionary_type (code TEXT, sid TEXT, is_delete INTEGER, status INTEGER);
+INSERT INTO fty_dictionary_type VALUES ('weight', 's1', 0, 1), ('color', 's2', 1, 1)`)
+	src.DB.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.exec("INSERT INTO token (uuid, name, db_name, template, params) VALUES (?, ?, ?, ?, ?)",
+		"t1", "status_is", "lite", "{{.col}} = {{.status}}",
+		"- name: status\n  type: string\n  required: true\n- name: col\n  type: ident\n")
+
+	tests := []struct {
+		name   string
+		tokens string
+		data   string
+		token  string
+		param  string
+	}{
+		{
+			"unknown attribute",
+			"    attrs:\n      params:\n        - name: weight\n        - name: height\n",
+			"SELECT id FROM orders %attrs",
+			"attrs", "height",
+		},
+		{
+			"deleted attribute",
+			"    attrs:\n      params:\n        - name: color\n",
+			"SELECT id FROM orders %attrs",
+			"attrs", "color",
+		},
+		{
+			"unsafe column",
+			"    attrs_fields:\n      params:\n        - name: weight\n          value: w;x\n",
+			"SELECT id, %attrs_fields FROM orders",
+			"attrs_fields", "weight",
+		},
+		{
+			"missing required param",
+			"    status_is:\n      params:\n        - name: col\n          value: status\n",
+			"SELECT id FROM orders WHERE %status_is",
+			"status_is", "",
+		},
+		{
+			"unsafe identifier",
+			"    status_is:\n      params:\n        - name: status\n          value: B\n        - name: col\n          value: a`b\n",
+			"SELECT id FROM orders WHERE %status_is",
+			"status_is", "col",
+		},
+	}
+	for i, tt := range tests {
+		path := fmt.Sprintf("/token/%d", i)
+		s.addDoc(path, tokenErrorDoc(tt.tokens, tt.data))
+
+		var res struct {
+			Err     string            `json:"err"`
+			Details map[string]string `json:"details"`
+		}
+		code := s.post("/sql-composer"+path, map[string]interface{}{"page_index": 1, "page_limit": 10}, &res)
+		if code != http.StatusUnprocessableEntity || res.Details["token"] != tt.token || res.Details["param"] != tt.param {
+			t.Errorf("%s: got %d %+v, want token %s param %q", tt.name, code, res, tt.token, tt.param)
+		}
+	}
+
+	// a failed token leaves the service serving the next requests
+	s.addDoc("/token/ok", tokenErrorDoc(
+		"    status_is:\n      params:\n        - name: status\n          value: B\n        - name: col\n          value: status\n",
+		"SELECT id FROM orders WHERE %status_is"))
+	var res struct {
+		Data []map[string]interface{} `json:"data"`
+	}
+	if code := s.post("/sql-composer/token/ok", map[string]interface{}{"page_index": 1, "page_limit": 10}, &res); code != http.StatusOK || len(res.Data) != 2 {
+		t.Errorf("after the failures: got %d %+v", code, res)
+	}
+}
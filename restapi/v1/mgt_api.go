@@ -5,24 +5,43 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/jmoiron/sqlx"
 	log "github.com/sirupsen/logrus"
+	"github.com/user/sqlcomposer-svc/composer"
+	"github.com/user/sqlcomposer-svc/datasource"
 	"github.com/user/sqlcomposer-svc/models"
 	"github.com/volatiletech/sqlboiler/v4/boil"
 	"net/http"
 	"strconv"
 )
 
-var db *sqlx.DB
+var (
+	db           *sqlx.DB
+	sources      *datasource.Registry
+	docs         *composer.Cache
+	dictionaries *datasource.Dictionaries
+	tokens       *composer.TokenCache
+)
 
 type Config struct {
-	DB *sqlx.DB
+	DB           *sqlx.DB
+	DataSources  *datasource.Registry
+	Docs         *composer.Cache
+	Dictionaries *datasource.Dictionaries
+	Tokens       *composer.TokenCache
 }
 
 func Setup(cfg *Config) {
 	//init db
 	db = cfg.DB
+	sources = cfg.DataSources
+	docs = cfg.Docs
+	dictionaries = cfg.Dictionaries
+	tokens = cfg.Tokens
 }
 
 func Destroy() {
+	if sources != nil {
+		sources.Close()
+	}
 }
 
 func errJSON(err error) map[string]interface{} {
@@ -89,6 +108,13 @@ func DocUpdateHandler() gin.HandlerFunc {
 		}
 
 		docFound, err := models.FindDoc(context, db, id)
+		if err != nil {
+			log.Error(err)
+			context.JSON(http.StatusNotFound, errJSON(err))
+			return
+		}
+
+		oldPath := docFound.Path.String
 		err = context.Bind(&docFound)
 
 		if err != nil {
@@ -108,6 +134,9 @@ func DocUpdateHandler() gin.HandlerFunc {
 			return
 		}
 
+		docs.Invalidate(oldPath)
+		docs.Invalidate(docFound.Path.String)
+
 		context.JSON(http.StatusOK, docFound)
 	}
 }
@@ -129,6 +158,8 @@ func DocAddHandler() gin.HandlerFunc {
 			return
 		}
 
+		docs.Invalidate(doc.Path.String)
+
 		context.JSON(http.StatusOK, doc)
 	}
 }
@@ -156,6 +187,8 @@ func DocDeleteHandler() gin.HandlerFunc {
 			return
 		}
 
+		docs.Invalidate(docFound.Path.String)
+
 		context.JSON(http.StatusOK, "delete success")
 	}
 }
@@ -218,6 +251,13 @@ func DSNUpdateHandler() gin.HandlerFunc {
 		}
 
 		dsnFound, err := models.FindDatabaseConfig(context, db, id)
+		if err != nil {
+			log.Error(err)
+			context.JSON(http.StatusNotFound, errJSON(err))
+			return
+		}
+
+		oldName := dsnFound.Name.String
 		err = context.Bind(&dsnFound)
 
 		if err != nil {
@@ -226,6 +266,11 @@ func DSNUpdateHandler() gin.HandlerFunc {
 			return
 		}
 
+		if _, err := datasource.DialectOf(dsnFound.Driver); err != nil {
+			context.JSON(http.StatusBadRequest, errJSON(err))
+			return
+		}
+
 		if rowsAff, err := dsnFound.Update(context, db, boil.Infer()); err != nil {
 			log.Error(err)
 			context.JSON(http.StatusInternalServerError, errJSON(err))
@@ -237,6 +282,9 @@ func DSNUpdateHandler() gin.HandlerFunc {
 			return
 		}
 
+		sources.Evict(oldName)
+		dictionaries.Invalidate(oldName)
+
 		context.JSON(http.StatusOK, dsnFound)
 	}
 }
@@ -252,6 +300,11 @@ func DSNAddHandler() gin.HandlerFunc {
 			return
 		}
 
+		if _, err := datasource.DialectOf(DSN.Driver); err != nil {
+			context.JSON(http.StatusBadRequest, errJSON(err))
+			return
+		}
+
 		if err := DSN.Insert(boil.WithDebug(context, true), db, boil.Infer()); err != nil {
 			log.Error(err)
 			context.JSON(http.StatusInternalServerError, errJSON(err))
@@ -285,6 +338,181 @@ func DSNDeleteHandler() gin.HandlerFunc {
 			return
 		}
 
+		sources.Evict(dsnFound.Name.String)
+		dictionaries.Invalidate(dsnFound.Name.String)
+
+		context.JSON(http.StatusOK, "delete success")
+	}
+}
+
+// DSNDictionaryInvalidateHandler drops the cached dictionary types of a
+// datasource, they are loaded again by the next request using them.
+func DSNDictionaryInvalidateHandler() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		id, err := strconv.Atoi(context.Param("id"))
+		if err != nil {
+			context.JSON(http.StatusBadRequest, fmt.Sprintf("ID param is required, %s", err))
+			return
+		}
+
+		dsnFound, err := models.FindDatabaseConfig(context, db, id)
+		if err != nil {
+			log.Error(err)
+			context.JSON(http.StatusNotFound, errJSON(err))
+			return
+		}
+
+		dictionaries.Invalidate(dsnFound.Name.String)
+
+		context.JSON(http.StatusOK, "dictionary invalidated")
+	}
+}
+
+func TokenListHandler() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		res, err := models.Tokens().All(context, db)
+
+		if err != nil {
+			log.Error(err)
+			context.JSON(http.StatusInternalServerError, errJSON(err))
+			return
+		}
+
+		total, err := models.Tokens().Count(context, db)
+
+		if err != nil {
+			log.Error(err)
+			context.JSON(http.StatusInternalServerError, errJSON(err))
+			return
+		}
+
+		context.JSON(http.StatusOK, &map[string]interface{}{
+			"data":  res,
+			"total": total,
+		})
+	}
+}
+
+func TokenGetHandler() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		id, err := strconv.Atoi(context.Param("id"))
+		if err != nil {
+			context.JSON(http.StatusBadRequest, fmt.Sprintf("ID param is required, %s", err))
+			return
+		}
+
+		tokenFound, err := models.FindToken(context, db, id)
+
+		if err != nil {
+			log.Error(err)
+			context.JSON(http.StatusNotFound, errJSON(err))
+			return
+		}
+
+		context.JSON(http.StatusOK, tokenFound)
+	}
+}
+
+// TokenUpdateHandler updates a stored token, the new definition must compile.
+func TokenUpdateHandler() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		id, err := strconv.Atoi(context.Param("id"))
+		if err != nil {
+			context.JSON(http.StatusBadRequest, fmt.Sprintf("ID param is required, %s", err))
+			return
+		}
+
+		tokenFound, err := models.FindToken(context, db, id)
+		if err != nil {
+			log.Error(err)
+			context.JSON(http.StatusNotFound, errJSON(err))
+			return
+		}
+
+		oldDBName, oldName := tokenFound.DBName, tokenFound.Name
+		err = context.Bind(&tokenFound)
+
+		if err != nil {
+			log.Error(err)
+			context.JSON(http.StatusBadRequest, errJSON(err))
+			return
+		}
+
+		if _, err := composer.CompileToken(tokenFound); err != nil {
+			context.JSON(http.StatusBadRequest, errJSON(err))
+			return
+		}
+
+		if rowsAff, err := tokenFound.Update(context, db, boil.Infer()); err != nil {
+			log.Error(err)
+			context.JSON(http.StatusInternalServerError, errJSON(err))
+			return
+		} else if rowsAff != 1 {
+			log.Error("should only affect one row but affected", rowsAff)
+			context.JSON(http.StatusInternalServerError,
+				fmt.Sprintf("should only affect one row but affected, %d affected", rowsAff))
+			return
+		}
+
+		tokens.Invalidate(oldDBName, oldName)
+		tokens.Invalidate(tokenFound.DBName, tokenFound.Name)
+
+		context.JSON(http.StatusOK, tokenFound)
+	}
+}
+
+// TokenAddHandler stores a token, its definition must compile.
+func TokenAddHandler() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		var token models.Token
+		err := context.Bind(&token)
+
+		if err != nil {
+			log.Error(err)
+			context.JSON(http.StatusBadRequest, errJSON(err))
+			return
+		}
+
+		if _, err := composer.CompileToken(&token); err != nil {
+			context.JSON(http.StatusBadRequest, errJSON(err))
+			return
+		}
+
+		if err := token.Insert(context, db, boil.Infer()); err != nil {
+			log.Error(err)
+			context.JSON(http.StatusInternalServerError, errJSON(err))
+			return
+		}
+
+		tokens.Invalidate(token.DBName, token.Name)
+
+		context.JSON(http.StatusOK, token)
+	}
+}
+
+func TokenDeleteHandler() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		id, err := strconv.Atoi(context.Param("id"))
+		if err != nil {
+			context.JSON(http.StatusBadRequest, fmt.Sprintf("ID param is required, %s", err))
+			return
+		}
+
+		tokenFound, err := models.FindToken(context, db, id)
+		if err != nil {
+			log.Error(err)
+			context.JSON(http.StatusNotFound, fmt.Sprintf("not found token by id %d, %s", id, err))
+			return
+		}
+
+		if _, err := tokenFound.Delete(context, db); err != nil {
+			log.Error(err)
+			context.JSON(http.StatusInternalServerError, errJSON(err))
+			return
+		}
+
+		tokens.Invalidate(tokenFound.DBName, tokenFound.Name)
+
 		context.JSON(http.StatusOK, "delete success")
 	}
 }